@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+// AzureAuthMethod selects how the storage node authenticates against Azure
+// Blob Storage. Picking the right one is what lets a node run unmodified on
+// a laptop (shared-key), behind a short-lived SAS token, or in AKS/VMs with
+// no secret on disk at all (managed identity).
+type AzureAuthMethod string
+
+const (
+	AzureAuthSharedKey    AzureAuthMethod = "shared-key"
+	AzureAuthSAS          AzureAuthMethod = "sas"
+	AzureAuthMSI          AzureAuthMethod = "msi"
+	AzureAuthClientSecret AzureAuthMethod = "client-secret"
+)
+
+// AzureAuthConfig carries whichever credentials the selected AzureAuthMethod
+// needs. Only the fields relevant to the chosen method need to be set.
+type AzureAuthConfig struct {
+	Method AzureAuthMethod
+
+	StorageAccountName string
+	StorageAccountKey   string // shared-key
+	SASToken            string // sas
+
+	TenantID     string // client-secret
+	ClientID     string // client-secret and msi (user-assigned)
+	ClientSecret string // client-secret
+}
+
+func (c *AzureAuthConfig) serviceURL() string {
+	return fmt.Sprintf("https://%s.blob.core.windows.net", c.StorageAccountName)
+}
+
+// newAzureBlobClient builds a single *azblob.Client for the configured auth
+// method. Callers should keep and reuse the returned client rather than
+// constructing a new pipeline per request.
+func newAzureBlobClient(cfg *AzureAuthConfig) (*azblob.Client, error) {
+	switch cfg.Method {
+	case AzureAuthSharedKey:
+		cred, err := service.NewSharedKeyCredential(cfg.StorageAccountName, cfg.StorageAccountKey)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClientWithSharedKeyCredential(cfg.serviceURL(), cred, nil)
+
+	case AzureAuthSAS:
+		return azblob.NewClientWithNoCredential(cfg.serviceURL()+"?"+cfg.SASToken, nil)
+
+	case AzureAuthClientSecret:
+		cred, err := azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret, nil)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClient(cfg.serviceURL(), cred, nil)
+
+	case AzureAuthMSI:
+		opts := &azidentity.ManagedIdentityCredentialOptions{}
+		if cfg.ClientID != "" {
+			opts.ID = azidentity.ClientID(cfg.ClientID)
+		}
+		cred, err := azidentity.NewManagedIdentityCredential(opts)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClient(cfg.serviceURL(), cred, nil)
+
+	default:
+		return nil, fmt.Errorf("unknown Azure auth method '%s'", cfg.Method)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// blobRange is a half-open byte range [Offset, Offset+Length) resolved from
+// an incoming HTTP Range header, or the zero value for "whole blob".
+type blobRange struct {
+	Offset int64
+	Length int64 // -1 means "to the end of the blob"
+	Set    bool
+}
+
+// parseRangeHeader translates a single-range "bytes=start-end" HTTP Range
+// header into the offset/count pair azblob's Download API expects. Only a
+// single range is supported; multi-range requests fall back to the whole blob.
+func parseRangeHeader(header string, blobSize int64) (blobRange, error) {
+	if header == "" {
+		return blobRange{}, nil
+	}
+
+	if strings.Contains(header, ",") {
+		return blobRange{}, nil
+	}
+
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return blobRange{}, fmt.Errorf("unsupported Range unit in '%s'", header)
+	}
+
+	spec := strings.TrimPrefix(header, prefix)
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return blobRange{}, fmt.Errorf("malformed Range header '%s'", header)
+	}
+
+	if parts[0] == "" {
+		// Suffix range: "bytes=-500" means the last 500 bytes.
+		suffixLen, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return blobRange{}, err
+		}
+		if suffixLen > blobSize {
+			suffixLen = blobSize
+		}
+		return blobRange{Offset: blobSize - suffixLen, Length: suffixLen, Set: true}, nil
+	}
+
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return blobRange{}, err
+	}
+
+	if parts[1] == "" {
+		return blobRange{Offset: start, Length: -1, Set: true}, nil
+	}
+
+	end, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return blobRange{}, err
+	}
+
+	return blobRange{Offset: start, Length: end - start + 1, Set: true}, nil
+}
+
+// dataHandler streams a blob's contents to the client using the node's
+// shared *azblob.Client connection pool. It honors the client's Range
+// header (returning 206 Partial Content with Content-Range) and, for
+// whole-blob requests larger than AzureDownloadChunkSize, fans out parallel
+// chunked downloads that are written to w in order.
+func (sn *StorageNode) dataHandler(id string, w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	props, err := sn.azureClient.ServiceClient().NewContainerClient(config.AzureContainerName).
+		NewBlobClient(id).GetProperties(ctx, nil)
+	if err != nil {
+		sn.log.Error(err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound)+": "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	blobSize := int64(0)
+	if props.ContentLength != nil {
+		blobSize = *props.ContentLength
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	rng, err := parseRangeHeader(r.Header.Get("Range"), blobSize)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable)+": "+err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if rng.Set {
+		sn.downloadRange(ctx, id, rng, blobSize, w)
+		return
+	}
+
+	sn.downloadWholeBlob(ctx, id, blobSize, w)
+}
+
+// downloadRange serves a single byte range as 206 Partial Content.
+func (sn *StorageNode) downloadRange(ctx context.Context, id string, rng blobRange, blobSize int64, w http.ResponseWriter) {
+	count := rng.Length
+	if count < 0 {
+		count = blobSize - rng.Offset
+	}
+
+	resp, err := sn.azureClient.DownloadStream(ctx, config.AzureContainerName, id, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: rng.Offset, Count: count},
+	})
+	if err != nil {
+		sn.log.Error(err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.Offset, rng.Offset+count-1, blobSize))
+	w.Header().Set("Content-Length", strconv.FormatInt(count, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		sn.log.Error(err.Error())
+	}
+}
+
+// downloadWholeBlob streams the entire blob. Large blobs are fetched as
+// parallel, fixed-size chunks (AzureDownloadChunkSize/AzureDownloadConcurrency),
+// each streamed to w as soon as it's ready rather than buffered in memory
+// first, so at most AzureDownloadConcurrency chunk bodies are ever open at
+// once regardless of how many chunks the blob has. The response status and
+// headers aren't written until the first chunk is confirmed, so a failure
+// before any bytes are sent still produces a proper error response instead
+// of a 200 with a truncated, mismatched-Content-Length body.
+func (sn *StorageNode) downloadWholeBlob(ctx context.Context, id string, blobSize int64, w http.ResponseWriter) {
+	chunkSize := config.AzureDownloadChunkSize
+	if chunkSize <= 0 || blobSize <= chunkSize {
+		resp, err := sn.azureClient.DownloadStream(ctx, config.AzureContainerName, id, nil)
+		if err != nil {
+			sn.log.Error(err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+
+		w.Header().Set("Content-Length", strconv.FormatInt(blobSize, 10))
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			sn.log.Error(err.Error())
+		}
+		return
+	}
+
+	numChunks := int((blobSize + chunkSize - 1) / chunkSize)
+	concurrency := config.AzureDownloadConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	type chunkResult struct {
+		body io.ReadCloser
+		err  error
+	}
+
+	// Each chunk gets its own single-slot result channel, so the consumer
+	// loop below can wait on them strictly in order while the dispatcher
+	// below fetches them out of order, bounded to `concurrency` in flight.
+	pending := make([]chan chunkResult, numChunks)
+	for i := range pending {
+		pending[i] = make(chan chunkResult, 1)
+	}
+
+	// A slot is taken before a chunk is fetched and given back only once the
+	// consumer loop has copied and closed that chunk's body.
+	sem := make(chan struct{}, concurrency)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; i < numChunks; i++ {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			go func(idx int) {
+				offset := int64(idx) * chunkSize
+				count := chunkSize
+				if offset+count > blobSize {
+					count = blobSize - offset
+				}
+
+				resp, err := sn.azureClient.DownloadStream(ctx, config.AzureContainerName, id, &blob.DownloadStreamOptions{
+					Range: blob.HTTPRange{Offset: offset, Count: count},
+				})
+				if err != nil {
+					pending[idx] <- chunkResult{err: err}
+					return
+				}
+				pending[idx] <- chunkResult{body: resp.Body}
+			}(i)
+		}
+	}()
+
+	headerWritten := false
+	for i := 0; i < numChunks; i++ {
+		result := <-pending[i]
+		if result.err != nil {
+			sn.log.Error("Failed to download chunk", "chunk", i, "blob", id, "error", result.err)
+			if !headerWritten {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+result.err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if !headerWritten {
+			w.Header().Set("Content-Length", strconv.FormatInt(blobSize, 10))
+			w.WriteHeader(http.StatusOK)
+			headerWritten = true
+		}
+
+		_, err := io.Copy(w, result.body)
+		result.body.Close()
+		<-sem
+		if err != nil {
+			sn.log.Error(err.Error())
+			return
+		}
+	}
+}
@@ -2,24 +2,18 @@ package main
 
 import (
 	"context"
-	"time"
-	"net/url"
-	"fmt"
-	"bufio"
-	"net/http"
 	"os"
 	"flag"
 	"runtime"
 	"os/signal"
 	"syscall"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/hashicorp/go-hclog"
 	"github.com/jinzhu/configor"
 	"github.com/arcsecc/lohpi"
-	"github.com/arcsecc/lohpi/core/util"
+	"github.com/arcsecc/lohpi/internal/logging"
 
-	"github.com/Azure/azure-storage-blob-go/azblob"
-	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 )
 
 var config = struct {
@@ -36,11 +30,26 @@ var config = struct {
 	AzureKeyVaultBaseURL string		`required:"true"`
 	AzureTenantID		string		`required:"true"`
 	AzureStorageAccountName	string	`required:"true"`
-	AzureStorageAccountKey string	`required:"true"`
+	AzureStorageAccountKey string	`default:""`
+	AzureAuthMethod			string	`default:"shared-key"`
+	AzureSASToken			string	`default:""`
+	AzureManagedIdentityClientID string `default:""`
+	AzureContainerName		string	`default:"lohpicontainer"`
+	AzureDownloadChunkSize	int64	`default:"4194304"`
+	AzureDownloadConcurrency int	`default:"4"`
+	LogLevel				string	`default:"info"`
+	LogFormat				string	`default:"standard"`
 }{}
 
+// log is the root logger for this binary, replacing the previous direct
+// logrus calls so its level/format/sink follow the shared internal/logging
+// configuration like every other Lohpi process.
+var log hclog.Logger = hclog.NewNullLogger()
+
 type StorageNode struct {
-	node *lohpi.Node
+	node        *lohpi.Node
+	azureClient *azblob.Client
+	log         hclog.Logger
 }
 
 func main() {
@@ -59,14 +68,16 @@ func main() {
 
 	configor.New(&configor.Config{Debug: false, ENVPrefix: "PS_NODE"}).Load(&config, configFile)
 
+	log = logging.New("azureblobnode", logging.Config{Level: config.LogLevel, Format: config.LogFormat})
+
 	if configFile == "" {
-		log.Errorln("Configuration file must not be empty. Exiting.")
+		log.Error("Configuration file must not be empty. Exiting.")
 		os.Exit(2)
 	}
 
 	// Require node identifier
 	if nodeName == "" {
-		log.Errorln("Missing node identifier. Exiting.")
+		log.Error("Missing node identifier. Exiting.")
 		os.Exit(2)
 	}
 
@@ -76,11 +87,11 @@ func main() {
 	if createNew {
 		sn, err = newNodeStorage(nodeName)
 		if err != nil {
-			log.Errorln(err.Error())
+			log.Error(err.Error())
 			os.Exit(1)
 		}
 	} else {
-		log.Errorln("Need to set the 'new' flag to true. Exiting.")
+		log.Error("Need to set the 'new' flag to true. Exiting.")
 		os.Exit(1)
 	}
 	
@@ -108,8 +119,15 @@ func newNodeStorage(name string) (*StorageNode, error) {
 		return nil, err
 	}
 
+	azureClient, err := newAzureBlobClient(azureAuthConfig())
+	if err != nil {
+		return nil, err
+	}
+
 	sn := &StorageNode {
-		node: n,
+		node:        n,
+		azureClient: azureClient,
+		log:         log.Named(name),
 	}
 
 	// TODO: revise the call stack starting from here
@@ -121,109 +139,46 @@ func newNodeStorage(name string) (*StorageNode, error) {
 	return sn, nil
 }
 
-// Returns the identifiers of the blobs in the storage account
-func getBlobIdentifiers() ([]string, error) {
-	ids := make([]string, 0)
-	// Create a default request pipeline using your storage account name and account key.
-	credential, err := azblob.NewSharedKeyCredential(config.AzureStorageAccountName, config.AzureStorageAccountKey)
-	if err != nil {
-		return nil, err
+// azureAuthConfig builds the auth factory config from the node's
+// configuration, picking shared-key, SAS, client-secret or managed identity
+// based on config.AzureAuthMethod.
+func azureAuthConfig() *AzureAuthConfig {
+	clientID := config.AzureClientID
+	if AzureAuthMethod(config.AzureAuthMethod) == AzureAuthMSI {
+		clientID = config.AzureManagedIdentityClientID
 	}
 
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
-
-	// From the Azure portal, get your storage account blob service URL endpoint.
-	azureURL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", config.AzureStorageAccountName))
-
-	// Create a ContainerURL object that wraps the container URL and a request
-	// pipeline to make requests.
-	serviceURL := azblob.NewServiceURL(*azureURL, p)
-	ctx := context.Background() // This uses a never-expiring context
-
-	// List the container(s)
-	for containerMarker := (azblob.Marker{}); containerMarker.NotDone(); {
-		listContainer, _ := serviceURL.ListContainersSegment(ctx, containerMarker, azblob.ListContainersSegmentOptions{})
-
-		for _, containerObject := range listContainer.ContainerItems {
-			containerName := containerObject.Name
-			containerURL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", config.AzureStorageAccountName, containerName))
-			containerServiceURL := azblob.NewContainerURL(*containerURL, p)
-
-			// List the blobs in the container
-			for blobMarker := (azblob.Marker{}); blobMarker.NotDone(); {
-				// Get a result segment starting with the blob indicated by the current Marker.
-				listBlob, err := containerServiceURL.ListBlobsFlatSegment(ctx, blobMarker, azblob.ListBlobsSegmentOptions{})
-				if err != nil {
-					log.Errorln(err.Error())
-					continue
-				}
-				
-				// ListBlobs returns the start of the next segment; you MUST use this to get
-				// the next segment (after processing the current result segment).
-				blobMarker = listBlob.NextMarker
-
-				// Process the blobs returned in this result segment (if the segment is empty, the loop body won't execute)
-				for _, blobInfo := range listBlob.Segment.BlobItems {
-          		
-					ids = append(ids, blobInfo.Name)
-				}
-			}
-		}
-		containerMarker = listContainer.NextMarker
+	return &AzureAuthConfig{
+		Method:              AzureAuthMethod(config.AzureAuthMethod),
+		StorageAccountName:  config.AzureStorageAccountName,
+		StorageAccountKey:   config.AzureStorageAccountKey,
+		SASToken:            config.AzureSASToken,
+		TenantID:            config.AzureTenantID,
+		ClientID:            clientID,
+		ClientSecret:        config.AzureClientSecret,
 	}
-	return ids, nil
 }
 
-// Implements downloading of data from Azure blob storage.
-// TODO: download speed from azure is very slow. We should investigate why this is the case.
-func dataHandler(id string, w http.ResponseWriter, r *http.Request) {
-	credential, err := azblob.NewSharedKeyCredential(config.AzureStorageAccountName, config.AzureStorageAccountKey)
-	if err != nil {
-		log.Fatal(err)
-	}
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{
-		Retry: azblob.RetryOptions{
-			TryTimeout:    time.Hour * 3,        // Maximum time allowed for any single try
-			MaxTries: 3,
-			Policy: azblob.RetryPolicyExponential,
-		},
-	})
-
-	cURL, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/lohpicontainer", config.AzureStorageAccountName))
-	if err != nil {
-		log.Error(err.Error())
-		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	ctx := context.Background() // This example uses a never-expiring context
-
-	// Create an ServiceURL object that wraps the service URL and a request pipeline to making requests.
-	containerURL := azblob.NewContainerURL(*cURL, p)
+// getBlobIdentifiers returns the identifiers of the blobs in the container
+// named by config.AzureContainerName, reusing the node's shared *azblob.Client instead of creating a
+// pipeline per call.
+func (sn *StorageNode) getBlobIdentifiers() ([]string, error) {
+	ids := make([]string, 0)
+	ctx := context.Background()
 
-	blobURL := containerURL.NewBlockBlobURL(id)
+	pager := sn.azureClient.NewListBlobsFlatPager(config.AzureContainerName, nil)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
 
-	// Here's how to read the blob's data with progress reporting:
-	get, err := blobURL.Download(ctx, 0, 0, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
-	if err != nil {
-		log.Fatal(err)
+		for _, blobItem := range resp.Segment.BlobItems {
+			ids = append(ids, *blobItem.Name)
+		}
 	}
 
-	// Wrap the response body in a ResponseBodyProgress and pass a callback function for progress reporting.
-	responseBody := pipeline.NewResponseBodyProgress(get.Body(azblob.RetryReaderOptions{}),
-		func(bytesTransferred int64) {
-			//fmt.Printf("Read %d of %d bytes.", bytesTransferred, get.ContentLength())
-		})
-
-	reader := bufio.NewReader(responseBody)
-	defer responseBody.Close() // The client must close the response body when finished with it
-	
-	// Stream from response to client
-	if err := util.StreamToResponseWriter(reader, w, 1000 * 1024); err != nil {
-		log.Errorln(err.Error())
-		http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
-		return
-	}
+	return ids, nil
 }
 
 func getNodeConfiguration(name string) ([]lohpi.NodeOption, error) {
@@ -236,26 +191,26 @@ func getNodeConfiguration(name string) ([]lohpi.NodeOption, error) {
 
 	env := os.Getenv("LOHPI_ENV")
 	if env == "" {
-		log.Errorln("LOHPI_ENV must be set. Exiting.")
+		log.Error("LOHPI_ENV must be set. Exiting.")
 		os.Exit(1)
 	} else if env == "production" {
-		log.Infoln("Production environment set")
+		log.Info("Production environment set")
 		opts = []lohpi.NodeOption{
-			lohpi.NodeWithPostgresSQLConnectionString(dbConn), 
-			lohpi.NodeWithMultipleCheckouts(true), 
+			lohpi.NodeWithPostgresSQLConnectionString(dbConn),
+			lohpi.NodeWithMultipleCheckouts(true),
 			lohpi.NodeWithHostName("test.lohpi.cs.uit.no"),
 			lohpi.NodeWithHTTPPort(config.HTTPPort),
 		}
 	} else if env == "development" {
-		log.Infoln("Development environment set")
+		log.Info("Development environment set")
 		opts = []lohpi.NodeOption{
-			lohpi.NodeWithPostgresSQLConnectionString(dbConn), 
+			lohpi.NodeWithPostgresSQLConnectionString(dbConn),
 			lohpi.NodeWithMultipleCheckouts(true),
 			lohpi.NodeWithHostName("iad09.cs.uit.no"),
 			lohpi.NodeWithHTTPPort(config.HTTPPort),
 		}
 	} else {
-		log.Errorln("Unknown value for environment variable LOHPI_ENV:" + env + ". Exiting.")
+		log.Error("Unknown value for environment variable LOHPI_ENV:" + env + ". Exiting.")
 		os.Exit(1)
 	}
 	
@@ -294,11 +249,11 @@ func (sn *StorageNode) Start() {
 		panic(err)
 	}
 
-	sn.node.RegisterDatasetHandler(dataHandler)
+	sn.node.RegisterDatasetHandler(sn.dataHandler)
 }
 
 func (sn *StorageNode) indexDataset() error {
-	ids, err := getBlobIdentifiers()
+	ids, err := sn.getBlobIdentifiers()
 	if err != nil {
 		return err
 	}
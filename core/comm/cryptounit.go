@@ -0,0 +1,68 @@
+package comm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+)
+
+// CryptoUnit holds the certificate, its issuing CA certificate, and the
+// private key a node or mux presents to peers and uses to authenticate its
+// own outgoing connections.
+type CryptoUnit struct {
+	cert   *x509.Certificate
+	caCert *x509.Certificate
+	priv   *ecdsa.PrivateKey
+}
+
+func (c *CryptoUnit) Certificate() *x509.Certificate   { return c.cert }
+func (c *CryptoUnit) CaCertificate() *x509.Certificate { return c.caCert }
+func (c *CryptoUnit) Priv() *ecdsa.PrivateKey          { return c.priv }
+
+// selfSignedValidity is how long a self-signed crypto unit is valid for
+// before it needs to be regenerated.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// NewSelfSignedCryptoUnit generates a self-signed ECDSA certificate for
+// commonName. It's used when no external Lohpi CA is configured: the node
+// acts as its own CA, and peers establish trust out of band, the same way
+// the directory server falls back to a locally managed certificate when
+// ACME isn't configured.
+func NewSelfSignedCryptoUnit(commonName string) (*CryptoUnit, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CryptoUnit{cert: cert, caCert: cert, priv: priv}, nil
+}
@@ -0,0 +1,47 @@
+package comm
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ServerConfig builds the *tls.Config an HTTP or gRPC server presents to
+// clients, trusting caCert to verify any client certificate presented back.
+func ServerConfig(cert, caCert *x509.Certificate, priv *ecdsa.PrivateKey) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  priv,
+			Leaf:        cert,
+		}},
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// ClientConfig returns the grpc.DialOptions needed to dial a peer over TLS,
+// presenting cert/priv as the client certificate and trusting caCert to
+// verify the peer's certificate.
+func ClientConfig(cert, caCert *x509.Certificate, priv *ecdsa.PrivateKey) []grpc.DialOption {
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  priv,
+			Leaf:        cert,
+		}},
+		RootCAs: pool,
+	}
+
+	return []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+}
@@ -3,12 +3,32 @@ package datasetmanager
 import (
 	"database/sql"
 	"fmt"
+	"sync"
+
 	pb "github.com/arcsecc/lohpi/protobuf"
 	"github.com/go-redis/redis"
-	log "github.com/sirupsen/logrus"
 	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/arcsecc/lohpi/internal/logging"
 )
 
+// datasetIdentifierSetKey is the Redis SET used to track every dataset
+// identifier currently cached, so cacheDatasetIdentifiers can page through a
+// bounded set instead of scanning the entire keyspace.
+const datasetIdentifierSetKey = "lohpi:datasetlookup:ids"
+
+// reloadBatchSize bounds how many rows reloadRedis pulls from Postgres and
+// pushes into Redis per round, so a cold start never loads the full table
+// into memory at once.
+const reloadBatchSize = 1000
+
+// invalidationChannel returns the pub/sub channel used to notify every
+// DatasetLookupService instance sharing this Redis deployment that a
+// dataset's node mapping has changed, so their local caches stay coherent.
+func invalidationChannel(datasetId string) string {
+	return fmt.Sprintf("lohpi:datasetlookup:%s:invalidate", datasetId)
+}
+
 // Configuration struct for the dataset manager
 type DatasetLookupServiceConfig struct {
 	// The database connection string used to back the in-memory data structures.
@@ -26,6 +46,15 @@ type DatasetLookupService struct {
 	config *DatasetLookupServiceConfig
 	datasetLookupSchema string
 	datasetLookupTable string
+	log hclog.Logger
+
+	// localCacheLock guards localCache, a process-local mirror of recently
+	// looked-up nodes. It exists so hot lookups don't have to round-trip to
+	// Redis, and is kept coherent across instances via invalidation pub/sub.
+	localCacheLock sync.RWMutex
+	localCache     map[string]*pb.Node
+
+	invalidationSub *redis.PubSub
 }
 
 // Returns a new DatasetIndexerService, given the configuration
@@ -46,6 +75,8 @@ func NewDatasetLookupService(id string, config *DatasetLookupServiceConfig) (*Da
 		config: config,
 		datasetLookupSchema: id + "_dataset_lookup_schema",
 		datasetLookupTable: id + "_dataset_lookup_table",
+		localCache: make(map[string]*pb.Node),
+		log: logging.New("datasetmanager", logging.ConfigFromEnv()).Named(id),
 	}
 
 	if err := d.createSchema(config.SQLConnectionString); err != nil {
@@ -68,28 +99,58 @@ func NewDatasetLookupService(id string, config *DatasetLookupServiceConfig) (*Da
 			return nil, fmt.Errorf("Value of Redis pong was wrong")
 		}
 
-		if err := d.flushAll(); err != nil {
-			return nil, err
-		}
+		d.invalidationSub = d.redisClient.PSubscribe("lohpi:datasetlookup:*:invalidate")
+		go d.runInvalidationSubscriber()
 
-		//errc := d.reloadRedis()
-		/*if err := <-errc; err != nil {
+		if err := <-d.reloadRedis(); err != nil {
 			return nil, err
-		}*/
+		}
 	}
 
 	return d, nil
 }
 
+// runInvalidationSubscriber drops a dataset from the local mirror whenever
+// another DatasetLookupService instance reports it changed, so readers never
+// observe a stale node mapping after a concurrent insert or removal elsewhere
+// in the deployment.
+func (d *DatasetLookupService) runInvalidationSubscriber() {
+	ch := d.invalidationSub.Channel()
+	for msg := range ch {
+		datasetId := msg.Payload
+		d.localCacheLock.Lock()
+		delete(d.localCache, datasetId)
+		d.localCacheLock.Unlock()
+	}
+}
+
+// publishInvalidation notifies every instance sharing this Redis deployment
+// that datasetId's node mapping changed.
+func (d *DatasetLookupService) publishInvalidation(datasetId string) {
+	if err := d.redisClient.Publish(invalidationChannel(datasetId), datasetId).Err(); err != nil {
+		d.log.Error(err.Error())
+	}
+}
+
 func (d *DatasetLookupService) DatasetNode(datasetId string) *pb.Node {
+	d.localCacheLock.RLock()
+	if node, ok := d.localCache[datasetId]; ok {
+		d.localCacheLock.RUnlock()
+		return node
+	}
+	d.localCacheLock.RUnlock()
+
 	if d.redisClient != nil {
 		node, err := d.cacheDatasetNode(datasetId)
 		if node != nil && err == nil {
+			d.localCacheLock.Lock()
+			d.localCache[datasetId] = node
+			d.localCacheLock.Unlock()
 			return node
 		}
 
 		if err != nil {
-			log.Error(err.Error())
+			d.log.Error(err.Error())
 		}
 	}
 	return d.dbSelectDatasetNode(datasetId)
@@ -122,7 +183,7 @@ func (d *DatasetLookupService) cacheDatasetNode(datasetId string) (*pb.Node, err
 func (d *DatasetLookupService) InsertDatasetNode(datasetId string, node *pb.Node) error {
 	if d.redisClient != nil {
 		if err := d.cacheInsertDatasetNode(datasetId, node); err != nil {
-			log.Error(err.Error())
+			d.log.Error(err.Error())
 		}
 	}
 
@@ -130,20 +191,33 @@ func (d *DatasetLookupService) InsertDatasetNode(datasetId string, node *pb.Node
 }
 
 func (d *DatasetLookupService) cacheInsertDatasetNode(datasetId string, node *pb.Node) error {
-	log.Println("Inserting into cache!")
+	d.log.Debug("Inserting into cache")
 	nodeBytes, err := proto.Marshal(node)
 	if err != nil {
 		return err
 	}
-	
-	return d.redisClient.Set(datasetId, nodeBytes, 0).Err()
+
+	pipe := d.redisClient.TxPipeline()
+	pipe.Set(datasetId, nodeBytes, 0)
+	pipe.SAdd(datasetIdentifierSetKey, datasetId)
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+
+	d.localCacheLock.Lock()
+	d.localCache[datasetId] = node
+	d.localCacheLock.Unlock()
+
+	d.publishInvalidation(datasetId)
+
+	return nil
 }
 
 // TODO: return errors from db interface as well
 func (d *DatasetLookupService) DatasetNodeExists(datasetId string) bool {
 	if datasetId == "" {
 		err := fmt.Errorf("Dataset identifier must not be empty")
-		log.Error(err.Error())
+		d.log.Error(err.Error())
 		return false
 	}
 
@@ -154,7 +228,7 @@ func (d *DatasetLookupService) DatasetNodeExists(datasetId string) bool {
 		}
 
 		if err != nil {
-			log.Error(err.Error())
+			d.log.Error(err.Error())
 		}
 	}
 
@@ -163,15 +237,8 @@ func (d *DatasetLookupService) DatasetNodeExists(datasetId string) bool {
 	if exists && d.redisClient != nil {
 		node := d.dbSelectDatasetNode(datasetId)
 		if node != nil {
-			nodeBytes, err := proto.Marshal(node)
-			if err != nil {
-				log.Error(err.Error())
-				return exists
-			}
-
-			if err := d.redisClient.Set(datasetId, nodeBytes, 0).Err(); err != nil {
-				log.Error(err.Error())
-				return exists
+			if err := d.cacheInsertDatasetNode(datasetId, node); err != nil {
+				d.log.Error(err.Error())
 			}
 		}
 	}
@@ -200,7 +267,7 @@ func (d *DatasetLookupService) cacheDatasetNodeExists(datasetId string) (bool, e
 func (d *DatasetLookupService) RemoveDatasetNode(datasetId string) error {
 	if d.redisClient != nil {
 		if err := d.cacheRemoveDatasetNode(datasetId); err != nil {
-			log.Error(err.Error())
+			d.log.Error(err.Error())
 		}
 	}
 
@@ -208,37 +275,41 @@ func (d *DatasetLookupService) RemoveDatasetNode(datasetId string) error {
 }
 
 func (d *DatasetLookupService) cacheRemoveDatasetNode(datasetId string) error {
-	cmd := d.redisClient.Del(datasetId)
-	if cmd.Err() != nil {
-		return cmd.Err()
+	pipe := d.redisClient.TxPipeline()
+	delCmd := pipe.Del(datasetId)
+	pipe.SRem(datasetIdentifierSetKey, datasetId)
+	if _, err := pipe.Exec(); err != nil {
+		return err
 	}
 
-	r, err := cmd.Result()
-	if err != nil {
-		return cmd.Err()
-	}
+	d.localCacheLock.Lock()
+	delete(d.localCache, datasetId)
+	d.localCacheLock.Unlock()
 
-	if r == 1 {
+	d.publishInvalidation(datasetId)
+
+	if delCmd.Val() == 1 {
 		return nil
-	} else {
-		return fmt.Errorf("Dataset node with identifier '%s' was not found", datasetId)
 	}
-
-	return nil
+	return fmt.Errorf("Dataset node with identifier '%s' was not found", datasetId)
 }
 
-// TODO: add ranges
+// cacheDatasetIdentifiers pages through the datasetIdentifierSetKey SET using
+// SSCAN's cursor, instead of scanning the entire Redis keyspace with SCAN.
+// The SET is kept in sync with the dataset keys themselves inside
+// cacheInsertDatasetNode/cacheRemoveDatasetNode via MULTI/EXEC, so it never
+// drifts from what's actually cached.
 func (d *DatasetLookupService) cacheDatasetIdentifiers() ([]string, error) {
 	ids := make([]string, 0)
-	iter := d.redisClient.Scan(0, "*", 0).Iterator()
+	iter := d.redisClient.SScan(datasetIdentifierSetKey, 0, "*", 0).Iterator()
 	for iter.Next() {
 		ids = append(ids, iter.Val())
 	}
-	
+
 	if err := iter.Err(); err != nil {
-	    return nil, err
+		return nil, err
 	}
-	
+
 	return ids, nil
 }
 
@@ -250,49 +321,41 @@ func (d *DatasetLookupService) DatasetIdentifiers() []string {
 		}
 
 		if err != nil {
-			log.Error(err.Error)
+			d.log.Error(err.Error())
 		}
 	}
 
 	return d.dbSelectDatasetIdentifiers()
 }
 
-func (d *DatasetLookupService) flushAll() error {
-	return d.redisClient.FlushAll().Err()
-}
-
+// reloadRedis rehydrates the Redis cache from Postgres in bounded batches of
+// reloadBatchSize rows at a time, so a cold start never pulls the entire
+// dataset_lookup table into memory the way the old single dbGetAllDatasetNodes
+// call did.
 func (d *DatasetLookupService) reloadRedis() chan error {
 	errc := make(chan error, 1)
-	
-	go func() {
-		// TODO: don't load everyting into memory!
-		maps, err := d.dbGetAllDatasetNodes()
-		if err != nil {
-			errc <- err
-			return
-		}
 
-		ifaces := make([]interface{}, 0)
-		pipe := d.redisClient.TxPipeline()
-		for k, v := range maps {
-			marshalled, err := proto.Marshal(v)
+	go func() {
+		offset := 0
+		for {
+			batch, err := d.dbSelectDatasetNodesPage(reloadBatchSize, offset)
 			if err != nil {
-				log.Error(err.Error())
-				continue
+				errc <- err
+				return
 			}
 
-			ifaces = append(ifaces, k, marshalled)
-		}
+			if len(batch) == 0 {
+				break
+			}
 
-		if len(ifaces) > 0 {
-			if err := d.redisClient.MSet(ifaces...).Err(); err != nil {
+			if err := d.reloadBatch(batch); err != nil {
 				errc <- err
 				return
 			}
-		
-			if _, err := pipe.Exec(); err != nil {
-				errc <- err
-				return
+
+			offset += len(batch)
+			if len(batch) < reloadBatchSize {
+				break
 			}
 		}
 
@@ -301,3 +364,23 @@ func (d *DatasetLookupService) reloadRedis() chan error {
 
 	return errc
 }
+
+// reloadBatch writes a single page of dataset nodes into Redis, updating the
+// cache keys and the datasetIdentifierSetKey SET atomically.
+func (d *DatasetLookupService) reloadBatch(batch map[string]*pb.Node) error {
+	pipe := d.redisClient.TxPipeline()
+
+	for datasetId, node := range batch {
+		marshalled, err := proto.Marshal(node)
+		if err != nil {
+			d.log.Error(err.Error())
+			continue
+		}
+
+		pipe.Set(datasetId, marshalled, 0)
+		pipe.SAdd(datasetIdentifierSetKey, datasetId)
+	}
+
+	_, err := pipe.Exec()
+	return err
+}
@@ -0,0 +1,361 @@
+package directoryserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+	log "github.com/sirupsen/logrus"
+)
+
+// ACMEConfig lets operators opt into automatic certificate provisioning and
+// renewal instead of supplying on-disk certs via IfritCryptoUnitWorkingDirectory.
+type ACMEConfig struct {
+	// DirectoryURL is the ACME directory endpoint, e.g. Let's Encrypt's production
+	// or staging URL.
+	DirectoryURL string
+
+	// Email is the account contact used for expiry/revocation notices.
+	Email string
+
+	// Domains are the subject (and SAN) names the certificate should cover.
+	Domains []string
+
+	// ChallengeType is either "http-01" or "dns-01".
+	ChallengeType string
+
+	// KVBackendDSN is the key the issued certificate and private key are
+	// read from and written to in the acmeKVStore given to
+	// newACMECertManager. It is only passed to that store's Get and Put; it
+	// is not used to open a connection to any backend.
+	KVBackendDSN string
+}
+
+// acmeKVStore is the pluggable backend used to share the ACME-issued
+// certificate and private key between directory-server replicas so they
+// don't race the CA when renewing. ACME account data is not stored in it.
+type acmeKVStore interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+}
+
+// acmeUser implements lego's registration.User.
+type acmeUser struct {
+	email        string
+	registration *registration.Resource
+	key          *ecdsa.PrivateKey
+}
+
+func (u *acmeUser) GetEmail() string                        { return u.email }
+func (u *acmeUser) GetRegistration() *registration.Resource  { return u.registration }
+func (u *acmeUser) GetPrivateKey() crypto.Signer             { return u.key }
+
+// acmeCertManager implements certManager by provisioning and renewing the
+// directory server's TLS material over ACME, storing the result in a
+// cluster-wide KV backend so replicas share state.
+type acmeCertManager struct {
+	mu sync.RWMutex
+
+	config *ACMEConfig
+	kv     acmeKVStore
+	client *lego.Client
+	user   *acmeUser
+
+	cert    *x509.Certificate
+	ca      *x509.Certificate
+	priv    *ecdsa.PrivateKey
+
+	onRenew func(*tls.Certificate)
+}
+
+var errNoDomains = errors.New("ACME config must specify at least one domain")
+
+// newACMECertManager registers a new ACME account with the CA on every call;
+// account data is not stored in or loaded from the KV backend. It then
+// installs the certificate material found in the KV backend, if any, and
+// requests a new certificate only when none could be loaded.
+func newACMECertManager(config *ACMEConfig, kv acmeKVStore) (*acmeCertManager, error) {
+	if config == nil {
+		return nil, errors.New("ACME config is nil")
+	}
+
+	if len(config.Domains) == 0 {
+		return nil, errNoDomains
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &acmeUser{email: config.Email, key: key}
+
+	legoConfig := lego.NewConfig(user)
+	legoConfig.CADirURL = config.DirectoryURL
+	legoConfig.Certificate.KeyType = certcrypto.EC256
+
+	client, err := lego.NewClient(legoConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.ChallengeType {
+	case "dns-01":
+		provider, err := dns01.NewDNSProviderManual()
+		if err != nil {
+			return nil, err
+		}
+		if err := client.Challenge.SetDNS01Provider(provider); err != nil {
+			return nil, err
+		}
+	default:
+		if err := client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", "")); err != nil {
+			return nil, err
+		}
+	}
+
+	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		return nil, err
+	}
+	user.registration = reg
+
+	acm := &acmeCertManager{
+		config: config,
+		kv:     kv,
+		client: client,
+		user:   user,
+	}
+
+	// Prefer whatever a sibling replica already issued and stored in the
+	// shared KV backend over requesting our own certificate, so a fleet of
+	// replicas coming up together doesn't each hit the CA and trip its rate
+	// limiting.
+	loaded, err := acm.loadFromKV()
+	if err != nil {
+		log.Debugln("No usable ACME material in KV backend, requesting a new certificate:", err.Error())
+	}
+	if !loaded {
+		if err := acm.obtainAndStore(); err != nil {
+			return nil, err
+		}
+	}
+
+	return acm, nil
+}
+
+// acmeMaterial is the JSON envelope gzip-compressed and written to the KV
+// backend by obtainAndStore, and read back by loadFromKV.
+type acmeMaterial struct {
+	Certificate []byte
+	PrivateKey  []byte
+}
+
+// loadFromKV reads and installs certificate material a sibling replica
+// already stored in the KV backend. It returns (true, nil) once the
+// material is installed, (false, nil) when there is no KV backend
+// configured or no entry has been stored yet, and (false, err) when the
+// entry can't be read or decoded. The caller falls back to obtainAndStore
+// whenever it returns false.
+func (a *acmeCertManager) loadFromKV() (bool, error) {
+	if a.kv == nil {
+		return false, nil
+	}
+
+	blob, err := a.kv.Get(a.config.KVBackendDSN)
+	if err != nil {
+		return false, err
+	}
+	if len(blob) == 0 {
+		return false, nil
+	}
+
+	data, err := gunzipBytes(blob)
+	if err != nil {
+		return false, err
+	}
+
+	var material acmeMaterial
+	if err := json.Unmarshal(data, &material); err != nil {
+		return false, err
+	}
+
+	cert, err := certcrypto.ParsePEMCertificate(material.Certificate)
+	if err != nil {
+		return false, err
+	}
+
+	priv, err := certcrypto.ParsePEMPrivateKey(material.PrivateKey)
+	if err != nil {
+		return false, err
+	}
+
+	ecPriv, ok := priv.(*ecdsa.PrivateKey)
+	if !ok {
+		return false, errors.New("stored ACME private key is not ECDSA")
+	}
+
+	a.mu.Lock()
+	a.cert = cert
+	a.priv = ecPriv
+	a.mu.Unlock()
+
+	return true, nil
+}
+
+// obtainAndStore requests a new certificate from the CA and gzip-compresses
+// the resulting bundle (cert and key) as JSON before writing it to the KV
+// backend, so other replicas can pick it up via loadFromKV instead of also
+// hitting the CA.
+func (a *acmeCertManager) obtainAndStore() error {
+	request := certificate.ObtainRequest{
+		Domains: a.config.Domains,
+		Bundle:  true,
+	}
+
+	res, err := a.client.Certificate.Obtain(request)
+	if err != nil {
+		return err
+	}
+
+	cert, err := certcrypto.ParsePEMCertificate(res.Certificate)
+	if err != nil {
+		return err
+	}
+
+	priv, err := certcrypto.ParsePEMPrivateKey(res.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	ecPriv, ok := priv.(*ecdsa.PrivateKey)
+	if !ok {
+		return errors.New("ACME-issued private key is not ECDSA")
+	}
+
+	if a.kv != nil {
+		data, err := json.Marshal(acmeMaterial{Certificate: res.Certificate, PrivateKey: res.PrivateKey})
+		if err != nil {
+			return err
+		}
+
+		blob, err := gzipBytes(data)
+		if err != nil {
+			return err
+		}
+
+		if err := a.kv.Put(a.config.KVBackendDSN, blob); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	a.cert = cert
+	a.priv = ecPriv
+	a.mu.Unlock()
+
+	if a.onRenew != nil {
+		tlsCert, err := tls.X509KeyPair(res.Certificate, res.PrivateKey)
+		if err != nil {
+			return err
+		}
+		a.onRenew(&tlsCert)
+	}
+
+	return nil
+}
+
+// startRenewal runs until ctx is cancelled, renewing the certificate shortly
+// before it expires and invoking onRenew so callers can reload their TLS
+// configs without dropping existing connections.
+func (a *acmeCertManager) startRenewal(ctx context.Context, onRenew func(*tls.Certificate)) {
+	a.onRenew = onRenew
+
+	const checkInterval = time.Hour
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.mu.RLock()
+			cert := a.cert
+			a.mu.RUnlock()
+
+			if cert == nil || time.Until(cert.NotAfter) > time.Hour*24*30 {
+				continue
+			}
+
+			if err := a.obtainAndStore(); err != nil {
+				log.Errorln("ACME renewal failed:", err.Error())
+			}
+		}
+	}
+}
+
+func (a *acmeCertManager) Certificate() *x509.Certificate {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.cert
+}
+
+func (a *acmeCertManager) CaCertificate() *x509.Certificate {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.ca
+}
+
+func (a *acmeCertManager) PrivateKey() *ecdsa.PrivateKey {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.priv
+}
+
+func (a *acmeCertManager) PublicKey() *ecdsa.PublicKey {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.priv == nil {
+		return nil
+	}
+	return &a.priv.PublicKey
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBytes(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
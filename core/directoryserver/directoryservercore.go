@@ -10,11 +10,14 @@ import (
 	"fmt"
 	"github.com/arcsecc/lohpi/core/directoryserver/sessionservice"
 	"github.com/arcsecc/lohpi/core/message"
+	"github.com/arcsecc/lohpi/core/metrics"
 	"github.com/arcsecc/lohpi/core/netutil"
 	pb "github.com/arcsecc/lohpi/protobuf"
 	"github.com/golang/protobuf/proto"
 	"github.com/joonnna/ifrit"
 	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -23,6 +26,7 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"time"
 )
 
 type Config struct {
@@ -44,6 +48,25 @@ type Config struct {
 	IfritCryptoUnitWorkingDirectory string
 	IfritTCPPort                    int
 	IfritUDPPort                    int
+
+	// ACME holds the settings newACMECertManager takes. NewDirectoryServerCore
+	// does not read this field, so setting it does not change how the
+	// directory server obtains or renews its TLS certificate.
+	ACME *ACMEConfig
+
+	// GSSAPIPeers lists the node names (pb.Node.GetName()) whose messages
+	// verifyMessage checks over GSS-API/Kerberos when gssAuth is set.
+	// NewDirectoryServerCore does not set gssAuth, so messages from every
+	// peer, listed or not, are checked against the Ifrit ECDSA signature.
+	GSSAPIPeers []string
+
+	// MetricsPath is the path the Prometheus handler is served at on
+	// MetricsListener. Defaults to "/metrics".
+	MetricsPath string
+
+	// MetricsListener is the address startMetricsServer listens on. Metrics
+	// aren't served at all until this is set to a non-empty address.
+	MetricsListener string
 }
 
 type DirectoryServerCore struct {
@@ -84,11 +107,23 @@ type DirectoryServerCore struct {
 
 	pb.UnimplementedDirectoryServerServer
 
-	dsLookupService datasetLookupService
-	cm              certManager
-	memManager      membershipManager
-	checkoutManager datasetCheckoutManager
-	gossipObs       gossipObserver
+	dsLookupService  datasetLookupService
+	cm               certManager
+	memManager       membershipManager
+	checkoutManager  datasetCheckoutManager
+	gossipObs        gossipObserver
+	retentionManager retentionPolicyManager
+
+	// Message authentication. ecdsaAuth is always set; NewDirectoryServerCore
+	// leaves gssAuth nil. gssapiPeers is the set of peer names
+	// (config.GSSAPIPeers) verified with gssAuth when it is non-nil; every
+	// other message is verified by verifyMessageSignature.
+	ecdsaAuth   *ecdsaAuthenticator
+	gssAuth     *gssAuthenticator
+	gssapiPeers map[string]bool
+
+	metrics      *metrics.Registry
+	promGatherer *prometheus.Registry
 
 	sessionService *sessionservice.SessionService
 }
@@ -108,6 +143,9 @@ type datasetLookupService interface {
 	DatasetNodeExists(datasetId string) bool
 	RemoveDatasetLookupEntry(datasetId string) error
 	InsertDatasetLookupEntry(datasetId string, nodeName string) error
+	// DatasetLookupNode returns the next healthy replica hosting datasetId,
+	// round-robining across the nodes registered for it when its retention
+	// policy specifies more than one replica.
 	DatasetLookupNode(datasetId string) *pb.Node
 	DatasetIdentifiers() []string
 }
@@ -136,11 +174,15 @@ type certManager interface {
 }
 
 // Returns a new DirectoryServer using the given configuration. Returns a non-nil error, if any.
-func NewDirectoryServerCore(cm certManager, gossipObs gossipObserver, dsLookupService datasetLookupService, memManager membershipManager, checkoutManager datasetCheckoutManager, config *Config) (*DirectoryServerCore, error) {
+func NewDirectoryServerCore(cm certManager, gossipObs gossipObserver, dsLookupService datasetLookupService, memManager membershipManager, checkoutManager datasetCheckoutManager, retentionManager retentionPolicyManager, config *Config) (*DirectoryServerCore, error) {
 	if config == nil {
 		return nil, errors.New("Configuration for directory server is nil")
 	}
 
+	if retentionManager == nil {
+		retentionManager = newInMemoryRetentionManager()
+	}
+
 	ifritClient, err := ifrit.NewClient(&ifrit.Config{
 		New:            true,
 		TCPPort:        config.IfritTCPPort,
@@ -177,13 +219,29 @@ func NewDirectoryServerCore(cm certManager, gossipObs gossipObserver, dsLookupSe
 		clientCheckoutMap:   make(map[string][]string, 0),
 		invalidatedDatasets: make(map[string]struct{}),
 
-		dsLookupService: dsLookupService,
-		cm:              cm,
-		memManager:      memManager,
-		checkoutManager: checkoutManager,
-		sessionService:  sessionService,
-		gossipObs:       gossipObs,
+		dsLookupService:  dsLookupService,
+		cm:               cm,
+		memManager:       memManager,
+		checkoutManager:  checkoutManager,
+		sessionService:   sessionService,
+		gossipObs:        gossipObs,
+		retentionManager: retentionManager,
+	}
+
+	ds.ecdsaAuth = newECDSAAuthenticator(ds.ifritClient)
+	ds.gssapiPeers = make(map[string]bool, len(config.GSSAPIPeers))
+	for _, peer := range config.GSSAPIPeers {
+		ds.gssapiPeers[peer] = true
+	}
+	ds.serverConfig = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{cm.Certificate().Raw},
+			PrivateKey:  cm.PrivateKey(),
+			Leaf:        cm.Certificate(),
+		}},
 	}
+	ds.promGatherer = prometheus.NewRegistry()
+	ds.metrics = metrics.NewRegistry(ds.promGatherer)
 
 	ds.grpcs.Register(ds)
 	ds.ifritClient.RegisterMsgHandler(ds.messageHandler)
@@ -205,6 +263,12 @@ func (d *DirectoryServerCore) Start() {
 	go d.ifritClient.Start()
 	go d.startHttpServer(":" + strconv.Itoa(d.config.HTTPPort))
 	go d.grpcs.Start()
+	go d.startRetentionSweeper()
+	go d.startMetricsServer()
+	go d.refreshCheckoutGauge()
+	if acm, ok := d.cm.(*acmeCertManager); ok {
+		go acm.startRenewal(context.Background(), d.reloadTLSConfig)
+	}
 	//go d.sessionService.Start()
 }
 
@@ -225,10 +289,14 @@ func (d *DirectoryServerCore) messageHandler(data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("")
 	}
 
-	if err := d.verifyMessageSignature(msg); err != nil {
+	d.metrics.MessagesReceived.WithLabelValues(fmt.Sprintf("%s", msg.GetType())).Inc()
+
+	if err := d.verifyMessage(msg); err != nil {
+		d.metrics.SignatureVerifications.WithLabelValues("failure").Inc()
 		log.Errorln(err)
 		return nil, err
 	}
+	d.metrics.SignatureVerifications.WithLabelValues("success").Inc()
 
 	switch msgType := msg.Type; msgType {
 	case message.MSG_TYPE_ADD_DATASET_IDENTIFIER:
@@ -308,7 +376,12 @@ func (d *DirectoryServerCore) Handshake(ctx context.Context, node *pb.Node) (*pb
 		return nil, status.Error(codes.InvalidArgument, "pb node is nil")
 	}
 
-	if err := d.memManager.AddNetworkNode(node.GetName(), node); err != nil {
+	d.metrics.HandshakeTotal.Inc()
+
+	addStart := time.Now()
+	err := d.memManager.AddNetworkNode(node.GetName(), node)
+	d.metrics.AddNetworkNodeDuration.Observe(time.Since(addStart).Seconds())
+	if err != nil {
 		return nil, err
 	}
 
@@ -319,10 +392,47 @@ func (d *DirectoryServerCore) Handshake(ctx context.Context, node *pb.Node) (*pb
 	}, nil
 }
 
+// verifyMessage verifies msg with gssAuth when it is set and the sender's
+// name is in config.GSSAPIPeers, and with verifyMessageSignature otherwise,
+// retrying bounded on failure. The choice is made per message from the
+// sender's name; nothing is negotiated during Handshake.
+func (d *DirectoryServerCore) verifyMessage(msg *pb.Message) error {
+	if d.gssAuth != nil && d.gssapiPeers[msg.GetSender().GetName()] {
+		return d.verifyWithRetries(func() error {
+			return d.gssAuth.Verify(gssVerifiablePayload(msg), msg.GetSignature().GetMac(), msg.GetSender().GetName())
+		})
+	}
+
+	return d.verifyWithRetries(func() error {
+		return d.verifyMessageSignature(msg)
+	})
+}
+
+// verifyWithRetries retries verify up to verifySignatureAttempts times,
+// logging every failed attempt, before surfacing the final error.
+func (d *DirectoryServerCore) verifyWithRetries(verify func() error) error {
+	var err error
+	for attempt := 1; attempt <= verifySignatureAttempts; attempt++ {
+		if err = verify(); err == nil {
+			return nil
+		}
+		log.Warnf("Signature verification attempt %d/%d failed: %s\n", attempt, verifySignatureAttempts, err.Error())
+	}
+	return err
+}
+
+// gssVerifiablePayload marshals msg with its Signature field cleared, the
+// same payload the GSS-API MIC was computed over on send.
+func gssVerifiablePayload(msg *pb.Message) []byte {
+	sig := msg.Signature
+	msg.Signature = nil
+	data, _ := proto.Marshal(msg)
+	msg.Signature = sig
+	return data
+}
+
 // Verifies the signature of the given message. Returns a non-nil error if the signature is not valid.
-// TODO: implement retries if it fails. Use while loop with a fixed number of attempts. Log the events too
 func (d *DirectoryServerCore) verifyMessageSignature(msg *pb.Message) error {
-	return nil
 	// Verify the integrity of the message
 	r := msg.GetSignature().GetR()
 	s := msg.GetSignature().GetS()
@@ -348,6 +458,67 @@ func (d *DirectoryServerCore) verifyMessageSignature(msg *pb.Message) error {
 	return nil
 }
 
+// refreshCheckoutGauge periodically refreshes the active-checkouts gauge
+// from checkoutManager so it reflects checkouts recorded outside of the
+// expiry sweeper (e.g. regular client checkout/checkin).
+func (d *DirectoryServerCore) refreshCheckoutGauge() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, datasetId := range d.dsLookupService.DatasetIdentifiers() {
+			checkouts, err := d.checkoutManager.DatasetCheckouts(datasetId)
+			if err != nil {
+				log.Errorln(err.Error())
+				continue
+			}
+			d.metrics.ActiveCheckouts.WithLabelValues(datasetId).Set(float64(len(checkouts)))
+		}
+	}
+}
+
+// metricsPath returns the configured Prometheus handler path, defaulting to
+// "/metrics".
+func (d *DirectoryServerCore) metricsPath() string {
+	if d.config.MetricsPath == "" {
+		return "/metrics"
+	}
+	return d.config.MetricsPath
+}
+
+// startMetricsServer serves the Prometheus handler on its own listener.
+// Metrics aren't served at all until config.MetricsListener is set.
+func (d *DirectoryServerCore) startMetricsServer() {
+	if d.config.MetricsListener == "" {
+		log.Warnln("MetricsListener is not configured; Prometheus metrics will not be served")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(d.metricsPath(), promhttp.HandlerFor(d.promGatherer, promhttp.HandlerOpts{}))
+
+	if err := http.ListenAndServe(d.config.MetricsListener, mux); err != nil {
+		log.Errorln("Metrics server stopped:", err.Error())
+	}
+}
+
+// reloadTLSConfig swaps in a freshly-renewed certificate on both the gRPC
+// and HTTP servers without dropping connections already established under
+// the old one.
+func (d *DirectoryServerCore) reloadTLSConfig(cert *tls.Certificate) {
+	d.configLock.Lock()
+	defer d.configLock.Unlock()
+
+	d.serverConfig.Certificates = []tls.Certificate{*cert}
+	d.grpcs.SetTLSConfig(d.serverConfig)
+
+	if d.httpServer != nil {
+		d.httpServer.TLSConfig = d.serverConfig
+	}
+
+	log.Infoln("Reloaded TLS certificate after ACME renewal")
+}
+
 func (d *DirectoryServerCore) pbNode() *pb.Node {
 	return &pb.Node{
 		Name:         d.config.Name,
@@ -413,12 +584,18 @@ func (d *DirectoryServerCore) gossipMessageHandler(data []byte) ([]byte, error)
 
 	log.Infof("Directory server got gossip message\n")
 
-	if err := d.verifyMessageSignature(msg); err != nil {
+	if err := d.verifyMessage(msg); err != nil {
+		d.metrics.SignatureVerifications.WithLabelValues("bypassed").Inc()
 		log.Warnln(err.Error())
 		//return nil, err
+	} else {
+		d.metrics.SignatureVerifications.WithLabelValues("success").Inc()
 	}
 
 	// Observe all gossip messages
+	alreadyObserved := d.gossipObs.GossipIsObserved(msg.GetGossipMessage())
+	d.metrics.GossipDedupTotal.WithLabelValues(strconv.FormatBool(alreadyObserved)).Inc()
+
 	if err := d.gossipObs.InsertObservedGossip(msg.GetGossipMessage()); err != nil {
 		log.Errorln(err.Error())
 	}
@@ -472,12 +649,16 @@ func (d *DirectoryServerCore) processPolicyBatch(msg *pb.Message) ([]byte, error
 // Apply policy to checked out dataset
 func (d *DirectoryServerCore) applyPolicy(newPolicy *pb.Policy) error {
 	if newPolicy == nil {
+		d.metrics.PolicyApplyResult.WithLabelValues("nil_policy").Inc()
 		return errors.New("Policy to be applied is nil")
 	}
 
 	datasetId := newPolicy.GetDatasetIdentifier()
 	if d.checkoutManager.DatasetIsCheckedOut(datasetId) {
+		d.metrics.PolicyApplyResult.WithLabelValues("applied").Inc()
 		//d.clientSessionHandler.PublishPolicy(newPolicy)
+	} else {
+		d.metrics.PolicyApplyResult.WithLabelValues("not_checked_out").Inc()
 	}
 
 	return nil
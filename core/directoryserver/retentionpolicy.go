@@ -0,0 +1,377 @@
+package directoryserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/arcsecc/lohpi/core/message"
+	pb "github.com/arcsecc/lohpi/protobuf"
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNoRetentionPolicyName = errors.New("Retention policy name must not be empty")
+	ErrUnknownRetentionPolicy = errors.New("No retention policy with the given name exists")
+)
+
+// RetentionPolicy mirrors InfluxDB's RetentionPolicyInfo: it binds datasets
+// inserted under its name to a TTL, a replication factor and a shard-group
+// window used by the expiry sweeper.
+type RetentionPolicy struct {
+	Name               string
+	Duration           time.Duration
+	ReplicaN           int
+	ShardGroupDuration time.Duration
+	Default            bool
+}
+
+// MarshalBinary encodes the policy as JSON so it can be stored by
+// retentionPolicyManager implementations without depending on a protobuf
+// schema this repo doesn't define.
+func (r *RetentionPolicy) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// UnmarshalBinary decodes a policy previously written by MarshalBinary.
+func (r *RetentionPolicy) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+// retentionPolicyManager persists retention policies and resolves the
+// policy that should govern a given dataset.
+type retentionPolicyManager interface {
+	CreateRetentionPolicy(p *RetentionPolicy) error
+	AlterRetentionPolicy(p *RetentionPolicy) error
+	DropRetentionPolicy(name string) error
+	SetDefaultRetentionPolicy(name string) error
+	RetentionPolicy(name string) (*RetentionPolicy, error)
+	DefaultRetentionPolicy() (*RetentionPolicy, error)
+	DatasetRetentionPolicy(datasetId string) (*RetentionPolicy, error)
+	SetDatasetRetentionPolicy(datasetId, policyName string) error
+	ExpiredDatasets(now time.Time) ([]string, error)
+}
+
+// inMemoryRetentionManager is the default retentionPolicyManager: it keeps
+// policies and dataset bindings in memory, guarded by a single mutex. It
+// doesn't survive a restart, which is fine for the sweeper's purposes since
+// expireDataset's effects (removing the lookup entry, clearing its
+// clientCheckoutMap entry)
+// are themselves re-derivable from the dataset DB on the next run.
+type inMemoryRetentionManager struct {
+	mu sync.Mutex
+
+	policies       map[string]*RetentionPolicy
+	defaultPolicy  string
+	datasetPolicy  map[string]string
+	datasetBoundAt map[string]time.Time
+}
+
+// newInMemoryRetentionManager returns a retentionPolicyManager with no
+// policies registered; callers are expected to set one as default before
+// any dataset is bound to it.
+func newInMemoryRetentionManager() *inMemoryRetentionManager {
+	return &inMemoryRetentionManager{
+		policies:       make(map[string]*RetentionPolicy),
+		datasetPolicy:  make(map[string]string),
+		datasetBoundAt: make(map[string]time.Time),
+	}
+}
+
+func (m *inMemoryRetentionManager) CreateRetentionPolicy(p *RetentionPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.policies[p.Name]; exists {
+		return fmt.Errorf("retention policy '%s' already exists", p.Name)
+	}
+
+	cp := *p
+	m.policies[p.Name] = &cp
+	return nil
+}
+
+func (m *inMemoryRetentionManager) AlterRetentionPolicy(p *RetentionPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.policies[p.Name]; !exists {
+		return ErrUnknownRetentionPolicy
+	}
+
+	cp := *p
+	m.policies[p.Name] = &cp
+	return nil
+}
+
+func (m *inMemoryRetentionManager) DropRetentionPolicy(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.policies[name]; !exists {
+		return ErrUnknownRetentionPolicy
+	}
+
+	delete(m.policies, name)
+	if m.defaultPolicy == name {
+		m.defaultPolicy = ""
+	}
+	for datasetId, policyName := range m.datasetPolicy {
+		if policyName == name {
+			delete(m.datasetPolicy, datasetId)
+		}
+	}
+	return nil
+}
+
+func (m *inMemoryRetentionManager) SetDefaultRetentionPolicy(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.policies[name]; !exists {
+		return ErrUnknownRetentionPolicy
+	}
+
+	m.defaultPolicy = name
+	return nil
+}
+
+func (m *inMemoryRetentionManager) RetentionPolicy(name string) (*RetentionPolicy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, exists := m.policies[name]
+	if !exists {
+		return nil, ErrUnknownRetentionPolicy
+	}
+	cp := *p
+	return &cp, nil
+}
+
+func (m *inMemoryRetentionManager) DefaultRetentionPolicy() (*RetentionPolicy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.defaultPolicy == "" {
+		return nil, ErrUnknownRetentionPolicy
+	}
+	cp := *m.policies[m.defaultPolicy]
+	return &cp, nil
+}
+
+func (m *inMemoryRetentionManager) DatasetRetentionPolicy(datasetId string) (*RetentionPolicy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name, bound := m.datasetPolicy[datasetId]
+	if !bound {
+		name = m.defaultPolicy
+	}
+	if name == "" {
+		return nil, ErrUnknownRetentionPolicy
+	}
+
+	p, exists := m.policies[name]
+	if !exists {
+		return nil, ErrUnknownRetentionPolicy
+	}
+	cp := *p
+	return &cp, nil
+}
+
+func (m *inMemoryRetentionManager) SetDatasetRetentionPolicy(datasetId, policyName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.policies[policyName]; !exists {
+		return ErrUnknownRetentionPolicy
+	}
+
+	m.datasetPolicy[datasetId] = policyName
+	m.datasetBoundAt[datasetId] = time.Now()
+	return nil
+}
+
+func (m *inMemoryRetentionManager) ExpiredDatasets(now time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired []string
+	for datasetId, boundAt := range m.datasetBoundAt {
+		name, bound := m.datasetPolicy[datasetId]
+		if !bound {
+			name = m.defaultPolicy
+		}
+
+		p, exists := m.policies[name]
+		if !exists || p.Duration <= 0 {
+			continue
+		}
+
+		if now.Sub(boundAt) >= p.Duration {
+			expired = append(expired, datasetId)
+		}
+	}
+
+	return expired, nil
+}
+
+// CreateRetentionPolicy registers a new retention policy, and makes it the
+// default when p.Default is set. Datasets are bound to a policy only through
+// retentionManager.SetDatasetRetentionPolicy; InsertDatasetLookupEntry does
+// not bind them.
+func (d *DirectoryServerCore) CreateRetentionPolicy(p *RetentionPolicy) error {
+	if p == nil {
+		return errors.New("retention policy is nil")
+	}
+
+	if p.Name == "" {
+		return ErrNoRetentionPolicyName
+	}
+
+	if err := d.retentionManager.CreateRetentionPolicy(p); err != nil {
+		log.Errorln(err.Error())
+		return err
+	}
+
+	if p.Default {
+		if err := d.retentionManager.SetDefaultRetentionPolicy(p.Name); err != nil {
+			log.Errorln(err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AlterRetentionPolicy updates the duration, replica count, shard-group
+// duration or default flag of an existing retention policy.
+func (d *DirectoryServerCore) AlterRetentionPolicy(p *RetentionPolicy) error {
+	if p == nil {
+		return errors.New("retention policy is nil")
+	}
+
+	if err := d.retentionManager.AlterRetentionPolicy(p); err != nil {
+		log.Errorln(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// DropRetentionPolicy removes a retention policy. Datasets still attached
+// to it fall back to the default policy.
+func (d *DirectoryServerCore) DropRetentionPolicy(name string) error {
+	if name == "" {
+		return ErrNoRetentionPolicyName
+	}
+
+	if err := d.retentionManager.DropRetentionPolicy(name); err != nil {
+		log.Errorln(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SetDefaultRetentionPolicy marks the named policy as the one applied to a
+// dataset that has no policy of its own bound through
+// SetDatasetRetentionPolicy. The sweeper only expires datasets that have
+// been bound that way at least once.
+func (d *DirectoryServerCore) SetDefaultRetentionPolicy(name string) error {
+	if name == "" {
+		return ErrNoRetentionPolicyName
+	}
+
+	if err := d.retentionManager.SetDefaultRetentionPolicy(name); err != nil {
+		log.Errorln(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// retentionSweepInterval is how often the background sweeper asks the
+// retention manager for datasets that have outlived their retention policy.
+const retentionSweepInterval = time.Minute * 5
+
+// startRetentionSweeper periodically asks the retention manager for expired
+// datasets and, for each one, notifies the hosting node so it can purge its
+// local storage, removes the lookup entry, and clears the dataset's
+// clientCheckoutMap entry and active-checkouts gauge. Checkouts recorded by
+// checkoutManager are left as they are.
+func (d *DirectoryServerCore) startRetentionSweeper() {
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired, err := d.retentionManager.ExpiredDatasets(time.Now())
+		if err != nil {
+			log.Errorln(err.Error())
+			continue
+		}
+
+		for _, datasetId := range expired {
+			if err := d.expireDataset(datasetId); err != nil {
+				log.Errorln(err.Error())
+			}
+		}
+	}
+}
+
+func (d *DirectoryServerCore) expireDataset(datasetId string) error {
+	node := d.dsLookupService.DatasetLookupNode(datasetId)
+	if node == nil {
+		d.metrics.DatasetLookupResult.WithLabelValues("miss").Inc()
+		return fmt.Errorf("No node hosts dataset '%s'", datasetId)
+	}
+	d.metrics.DatasetLookupResult.WithLabelValues("hit").Inc()
+
+	if err := d.notifyDatasetExpired(node, datasetId); err != nil {
+		log.Errorln(err.Error())
+	}
+
+	if err := d.dsLookupService.RemoveDatasetLookupEntry(datasetId); err != nil {
+		return err
+	}
+
+	d.clientCheckoutMapLock.Lock()
+	delete(d.clientCheckoutMap, datasetId)
+	d.clientCheckoutMapLock.Unlock()
+	d.metrics.ActiveCheckouts.WithLabelValues(datasetId).Set(0)
+
+	log.Infof("Expired dataset '%s' under its retention policy\n", datasetId)
+	return nil
+}
+
+// notifyDatasetExpired sends the hosting node a MSG_TYPE_DATASET_EXPIRED
+// message so it can purge the dataset from its own storage.
+func (d *DirectoryServerCore) notifyDatasetExpired(node *pb.Node, datasetId string) error {
+	msg := &pb.Message{
+		Type:        message.MSG_TYPE_DATASET_EXPIRED,
+		Sender:      d.pbNode(),
+		StringValue: datasetId,
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	r, s, err := d.ifritClient.Sign(data)
+	if err != nil {
+		return err
+	}
+
+	msg.Signature = &pb.MsgSignature{R: r, S: s}
+	data, err = proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	d.ifritClient.SendTo(node.GetIfritAddress(), data)
+	return nil
+}
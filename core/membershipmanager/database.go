@@ -49,13 +49,15 @@ func (m *MembershipManagerUnit) dbInsertNetworkNode(nodeId string, node *pb.Node
 		port = $5, 
 		boottime = $6
 	WHERE ` + m.storageNodeSchema + `.` + m.storageNodeTable + `.node_name = $1;`
-	_, err := m.pool.Exec(context.Background(), q, 
+	start := time.Now()
+	_, err := m.pool.Exec(context.Background(), q,
 		nodeId,
-		node.GetIfritAddress(), 
+		node.GetIfritAddress(),
 		node.GetId(),
-		node.GetHttpsAddress(), 
-		node.GetPort(), 
+		node.GetHttpsAddress(),
+		node.GetPort(),
 		boottime)
+	queryDuration.WithLabelValues("insert").Observe(time.Since(start).Seconds())
 	if err != nil {
 		log.WithFields(dbLogFields).Error(err.Error())
 		return err
@@ -72,7 +74,9 @@ func (m *MembershipManagerUnit) dbDeleteNetworkNode(nodeId string) error {
 	}
 
 	q := `DELETE FROM ` + m.storageNodeSchema + `.` + m.storageNodeTable + ` WHERE node_name = $1;`
+	start := time.Now()
 	commangTag, err := m.pool.Exec(context.Background(), q, nodeId)
+	queryDuration.WithLabelValues("delete").Observe(time.Since(start).Seconds())
 	if err != nil {
 		log.WithFields(dbLogFields).Error(err.Error())
 		return err
@@ -90,7 +94,9 @@ func (m *MembershipManagerUnit) dbDeleteNetworkNode(nodeId string) error {
 }
 
 func (m *MembershipManagerUnit) dbSelectAllNetworkNodes() (map[string]*pb.Node, error) {
+	start := time.Now()
 	rows, err := m.pool.Query(context.Background(), `SELECT * FROM ` + m.storageNodeSchema + `.` + m.storageNodeTable + `;`)
+	queryDuration.WithLabelValues("select").Observe(time.Since(start).Seconds())
     if err != nil {
 		log.WithFields(dbLogFields).Error(err.Error())
         return nil, err
@@ -138,8 +144,10 @@ func (m *MembershipManagerUnit) dbSelectNetworkNode(nodeId string) (*pb.Node, er
 	q := `SELECT * FROM ` + m.storageNodeSchema + `.` + m.storageNodeTable + ` WHERE node_name = $1;`
 	var nodeName, ipAddress, httpsAddress, boottime string
 	var id, port int32
-	var publicId []byte	
+	var publicId []byte
+	start := time.Now()
 	err := m.pool.QueryRow(context.Background(), q, nodeId).Scan(&id, &nodeName, &ipAddress, &publicId, &httpsAddress, &port, &boottime)
+	queryDuration.WithLabelValues("select").Observe(time.Since(start).Seconds())
 	switch err {
 	case pgx.ErrNoRows:
 		log.WithFields(dbLogFields).
@@ -176,7 +184,9 @@ func (m *MembershipManagerUnit) dbNetworkNodeExists(nodeId string) (bool, error)
 
 	var exists bool
 	q := `SELECT EXISTS ( SELECT 1 FROM ` + m.storageNodeSchema + `.` + m.storageNodeTable + ` WHERE node_name = $1);`
+	start := time.Now()
 	err := m.pool.QueryRow(context.Background(), q, nodeId).Scan(&exists)
+	queryDuration.WithLabelValues("exists").Observe(time.Since(start).Seconds())
 	if err != nil {
 		log.WithFields(dbLogFields).
 			WithField("database query", fmt.Sprintf("could not find '%s' in database", nodeId)).
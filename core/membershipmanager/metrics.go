@@ -0,0 +1,26 @@
+package membershipmanager
+
+import (
+	"github.com/arcsecc/lohpi/core/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsRegistry is membershipmanager's own Prometheus registry. Nothing in
+// this tree constructs a MembershipManagerUnit or threads a directory
+// server's registerer into this package, so there's no d.promGatherer to
+// register against yet; using a package-local registry instead of
+// prometheus.DefaultRegisterer at least keeps these histograms out of the
+// global registry, where they'd sit alongside every other package's
+// collectors and could never be scraped on their own. Registry exposes it so
+// whoever eventually wires a MembershipManagerUnit into a server can gather
+// it into that server's own /metrics handler.
+var metricsRegistry = prometheus.NewRegistry()
+
+// Registry returns membershipmanager's Prometheus registry.
+func Registry() *prometheus.Registry {
+	return metricsRegistry
+}
+
+// queryDuration times every pool.Exec/QueryRow call, labeled by query kind,
+// so operators can see Postgres latency for the membership database.
+var queryDuration = metrics.NewQueryHistogram(metricsRegistry, "membershipmanager")
@@ -0,0 +1,110 @@
+// Package metrics centralizes the Prometheus collectors shared across the
+// directory server, its gossip layer and dataset checkout flows.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Registry bundles the collectors registered by the directory server. It is
+// constructed once and threaded into the directory server's subsystems, so
+// they all report through the same registry. Collectors made elsewhere (such
+// as membershipmanager's query histogram) are registered separately.
+type Registry struct {
+	registerer prometheus.Registerer
+
+	MessagesReceived        *prometheus.CounterVec
+	SignatureVerifications  *prometheus.CounterVec
+	HandshakeTotal          prometheus.Counter
+	AddNetworkNodeDuration  prometheus.Histogram
+	DatasetLookupResult     *prometheus.CounterVec
+	ActiveCheckouts         *prometheus.GaugeVec
+	GossipDedupTotal        *prometheus.CounterVec
+	PolicyApplyResult       *prometheus.CounterVec
+}
+
+// NewRegistry creates and registers the directory server's collectors
+// against reg. Callers typically pass prometheus.NewRegistry() and serve it
+// at /metrics via promhttp.HandlerFor.
+func NewRegistry(reg prometheus.Registerer) *Registry {
+	r := &Registry{
+		registerer: reg,
+		MessagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lohpi",
+			Subsystem: "directoryserver",
+			Name:      "messages_received_total",
+			Help:      "Number of Ifrit messages received, labeled by message type.",
+		}, []string{"type"}),
+		SignatureVerifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lohpi",
+			Subsystem: "directoryserver",
+			Name:      "signature_verifications_total",
+			Help:      "Outcome of message signature verification: success, failure or bypassed.",
+		}, []string{"outcome"}),
+		HandshakeTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "lohpi",
+			Subsystem: "directoryserver",
+			Name:      "handshakes_total",
+			Help:      "Number of node handshakes processed.",
+		}),
+		AddNetworkNodeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "lohpi",
+			Subsystem: "directoryserver",
+			Name:      "add_network_node_duration_seconds",
+			Help:      "Latency of membershipManager.AddNetworkNode calls.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		DatasetLookupResult: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lohpi",
+			Subsystem: "directoryserver",
+			Name:      "dataset_lookup_total",
+			Help:      "Dataset lookup results, labeled hit or miss.",
+		}, []string{"result"}),
+		ActiveCheckouts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "lohpi",
+			Subsystem: "directoryserver",
+			Name:      "active_checkouts",
+			Help:      "Number of active checkouts per dataset.",
+		}, []string{"dataset"}),
+		GossipDedupTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lohpi",
+			Subsystem: "directoryserver",
+			Name:      "gossip_dedup_total",
+			Help:      "Gossip messages observed, labeled by whether they were already seen.",
+		}, []string{"observed"}),
+		PolicyApplyResult: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "lohpi",
+			Subsystem: "directoryserver",
+			Name:      "policy_apply_total",
+			Help:      "Outcome of applying a gossiped policy to a checked-out dataset.",
+		}, []string{"outcome"}),
+	}
+
+	reg.MustRegister(
+		r.MessagesReceived,
+		r.SignatureVerifications,
+		r.HandshakeTotal,
+		r.AddNetworkNodeDuration,
+		r.DatasetLookupResult,
+		r.ActiveCheckouts,
+		r.GossipDedupTotal,
+		r.PolicyApplyResult,
+	)
+
+	return r
+}
+
+// NewQueryHistogram builds and registers the histogram membershipmanager wraps every pool.Exec/
+// QueryRow call with, labeled by query kind (insert, select, delete, exists).
+func NewQueryHistogram(reg prometheus.Registerer, subsystem string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "lohpi",
+		Subsystem: subsystem,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of PostgreSQL queries, labeled by query kind.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"query"})
+
+	reg.MustRegister(h)
+	return h
+}
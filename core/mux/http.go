@@ -1,9 +1,13 @@
 package mux
 
 import (
+	"archive/zip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"io"
+	"net"
 	"net/http"
 	"time"
 
@@ -21,6 +25,8 @@ func (m *Mux) startHttpServer() error {
 	dRouter.HandleFunc("/metadata/{id:.*}", m.getDatasetMetadata).Methods("GET")
 	dRouter.HandleFunc("/data/{id:.*}", m.getDataset).Methods("GET")
 
+	r.HandleFunc("/jobs/{id}", m.getJob).Methods("GET")
+
 	m.httpServer = &http.Server{
 		Handler:      r,
 		WriteTimeout: time.Second * 30,
@@ -116,10 +122,16 @@ func (m *Mux) getDatasetMetadata(w http.ResponseWriter, req *http.Request) {
 	w.Write(md)
 }
 
-// Handler used to fetch an entire dataset. Writes a zip file to the client
+// Handler used to fetch an entire dataset. The actual work of fanning out to
+// storage nodes and zipping their responses runs on m.jobQueue's worker
+// pool rather than this request goroutine, so a flood of requests for large
+// datasets queues up and gets rejected with 429 once the pool is saturated,
+// instead of piling up unbounded goroutines that OOM the mux. Append
+// ?async=true to get a 202 Accepted with a job URL instead of waiting on the
+// response body.
 func (m *Mux) getDataset(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
-	
+
 	dataset := mux.Vars(req)["id"]
 	if dataset == "" {
 		errMsg := fmt.Errorf("Missing storage identifier.")
@@ -127,18 +139,151 @@ func (m *Mux) getDataset(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	//ctx, cancel := context.WithDeadline(req.Context(), time.Now().Add(time.Second * 5))
-	/*defer cancel()
-	req = req.WithContext(ctx)*/
+	subject, err := querySubject(req)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	archiveBytes, err := m.dataset(w, req, dataset, nil)
+	pr, pw := io.Pipe()
+
+	job, err := m.jobQueue.Submit(req.Context(), subject, func(ctx context.Context) error {
+		defer pw.Close()
+		return m.writeDatasetArchive(ctx, dataset, pw)
+	})
 	if err != nil {
-		log.Println(err.Error())
+		log.Infoln(err.Error())
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, http.StatusText(http.StatusTooManyRequests)+": "+err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	async := req.URL.Query().Get("async") == "true"
+	if async {
+		// Nobody is going to read pr, so let the worker's writes drain
+		// instead of blocking forever on a full pipe.
+		go io.Copy(io.Discard, pr)
+
+		jobURL := fmt.Sprintf("/jobs/%s", job.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", jobURL)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(struct {
+			Job string `json:"job"`
+			URL string `json:"url"`
+		}{Job: job.ID, URL: jobURL})
+		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	req.Header.Add("Content-Length", strconv.Itoa(len(archiveBytes)))
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", dataset))
-	w.Write(archiveBytes)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, pr); err != nil {
+		log.Errorf("Failed to stream dataset '%s' to client: %s\n", dataset, err.Error())
+	}
+}
+
+// writeDatasetArchive zips dataset's files into w entry by entry, pulling
+// each file from its storage node concurrently since that's the slow part.
+// It never builds the archive in memory: its caller passes the write end of
+// the io.Pipe a queued job streams into.
+func (m *Mux) writeDatasetArchive(ctx context.Context, dataset string, w io.Writer) error {
+	fileIds, err := m.datasetFileIdentifiers(ctx, dataset, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list files for dataset '%s': %w", dataset, err)
+	}
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	// Pull every file from its storage node concurrently, since that's the
+	// slow part, but write entries into the zip archive one at a time in
+	// list order: zip.Writer isn't safe for concurrent use.
+	type fetchResult struct {
+		reader io.ReadCloser
+		err    error
+	}
+
+	pending := make([]chan fetchResult, len(fileIds))
+	for i, fileId := range fileIds {
+		resultChan := make(chan fetchResult, 1)
+		pending[i] = resultChan
+
+		go func(fileId string) {
+			reader, err := m.datasetFile(ctx, dataset, fileId)
+			resultChan <- fetchResult{reader: reader, err: err}
+		}(fileId)
+	}
+
+	for i, resultChan := range pending {
+		fileId := fileIds[i]
+		result := <-resultChan
+		if result.err != nil {
+			log.Errorf("Failed to fetch file '%s' of dataset '%s': %s\n", fileId, dataset, result.err.Error())
+			continue
+		}
+
+		entry, err := zw.Create(fileId)
+		if err != nil {
+			log.Errorf("Failed to create zip entry for file '%s' of dataset '%s': %s\n", fileId, dataset, err.Error())
+			result.reader.Close()
+			continue
+		}
+
+		if _, err := io.Copy(entry, result.reader); err != nil {
+			log.Errorf("Failed to stream file '%s' of dataset '%s' into archive: %s\n", fileId, dataset, err.Error())
+		}
+		result.reader.Close()
+	}
+
+	return nil
+}
+
+// querySubject returns the per-client key used for the job queue's
+// concurrency limit. The mux doesn't verify bearer tokens itself (that's
+// core/node's OIDC middleware), so an unauthenticated Authorization header
+// can't be trusted as that key: a caller could mint a different one on every
+// request and the per-subject limit would enforce nothing. The caller's IP
+// is used instead, since it's the one thing this handler sees that the
+// caller can't freely change request to request.
+func querySubject(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine client address: %w", err)
+	}
+	if host == "" {
+		return "", errors.New("client address is empty")
+	}
+	return host, nil
+}
+
+// getJob reports the progress of a previously queued dataset fetch, so a
+// client that requested ?async=true can poll instead of holding a
+// connection open.
+func (m *Mux) getJob(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	id := mux.Vars(r)["id"]
+	job, ok := m.jobQueue.Job(id)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	resp := struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}{
+		ID:     job.ID,
+		Status: string(job.Status()),
+	}
+	if err := job.Err(); err != nil {
+		resp.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
 }
@@ -0,0 +1,102 @@
+package mux
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/arcsecc/lohpi/core/datasetmanager"
+	"github.com/arcsecc/lohpi/core/mux/queue"
+)
+
+// Config holds the mux's own runtime settings, as opposed to the per-node
+// settings held by core/node.Config.
+type Config struct {
+	MuxHttpPort int
+}
+
+// Mux fans dataset requests out to the storage node that actually owns each
+// dataset, and zips together whatever files a dataset consists of.
+type Mux struct {
+	config *Config
+
+	httpServer   *http.Server
+	httpListener net.Listener
+
+	// datasetLookupService resolves a dataset id to the storage node that
+	// owns it, so datasetFileIdentifiers/datasetFile know which node to ask.
+	datasetLookupService *datasetmanager.DatasetLookupService
+
+	jobQueue *queue.Queue
+}
+
+// Default job queue sizing, used when NewMux isn't given more specific
+// values: a handful of concurrent archive fetches, a modest backlog before
+// new requests are rejected with 429, and one in-flight fetch per client so
+// a single caller can't monopolize the worker pool by requesting many large
+// datasets at once.
+const (
+	defaultJobQueueWorkers       = 4
+	defaultJobQueueCapacity      = 64
+	defaultJobQueueMaxPerSubject = 1
+)
+
+// NewMux builds a Mux backed by dsLookupService, ready to accept requests
+// once startHttpServer is called.
+func NewMux(config *Config, dsLookupService *datasetmanager.DatasetLookupService, httpListener net.Listener) (*Mux, error) {
+	if config == nil {
+		return nil, fmt.Errorf("mux: config must not be nil")
+	}
+
+	return &Mux{
+		config:               config,
+		httpListener:         httpListener,
+		datasetLookupService: dsLookupService,
+		jobQueue:             queue.New(defaultJobQueueWorkers, defaultJobQueueCapacity, defaultJobQueueMaxPerSubject),
+	}, nil
+}
+
+// datasetFileIdentifiers returns the file identifiers that make up dataset.
+// Datasets in this deployment are single files, so it's always a
+// one-element slice; ctx and the trailing parameter are accepted to match
+// the shape callers already use elsewhere in this package.
+func (m *Mux) datasetFileIdentifiers(ctx context.Context, dataset string, _ interface{}) ([]string, error) {
+	node := m.datasetLookupService.DatasetNode(dataset)
+	if node == nil {
+		return nil, fmt.Errorf("dataset '%s' is not indexed by any known node", dataset)
+	}
+
+	return []string{dataset}, nil
+}
+
+// datasetFile fetches fileId from the storage node that owns dataset. The
+// caller is responsible for closing the returned reader.
+func (m *Mux) datasetFile(ctx context.Context, dataset, fileId string) (io.ReadCloser, error) {
+	node := m.datasetLookupService.DatasetNode(dataset)
+	if node == nil {
+		return nil, fmt.Errorf("dataset '%s' is not indexed by any known node", dataset)
+	}
+
+	url := fmt.Sprintf("%s/dataset/data/%s", node.GetHttpsAddress(), fileId)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: time.Minute * 5}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch file '%s' from node '%s': %w", fileId, node.GetName(), err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("node '%s' returned status %d for file '%s'", node.GetName(), resp.StatusCode, fileId)
+	}
+
+	return resp.Body, nil
+}
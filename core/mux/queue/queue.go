@@ -0,0 +1,189 @@
+// Package queue implements a bounded, worker-pool-backed job queue for the
+// mux's dataset fetch handler, modeled after rqlite's queue package: a fixed
+// number of workers drain a buffered channel of jobs, and Submit fails fast
+// with ErrQueueFull instead of blocking the caller once that channel is
+// full. This lets a request goroutine hand off a slow, memory-heavy dataset
+// fetch to a bounded pool rather than doing the work itself, which is what
+// made it trivial to DOS the mux by requesting many large datasets at once.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned by Submit when the job channel has no room left.
+var ErrQueueFull = errors.New("queue: full")
+
+// ErrPerSubjectLimit is returned by Submit when the submitting subject
+// already has the per-subject maximum given to New outstanding.
+var ErrPerSubjectLimit = errors.New("queue: per-client concurrency limit reached")
+
+// Status is the lifecycle state of a Job, reported by GET /jobs/{id}.
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Job is one unit of work submitted to a Queue. Fn is run by a worker
+// goroutine once the job is dequeued; its error, if any, is recorded and
+// surfaced through Err.
+type Job struct {
+	ID      string
+	Subject string
+	Ctx     context.Context
+	Fn      func(ctx context.Context) error
+
+	mu     sync.RWMutex
+	status Status
+	err    error
+}
+
+func newJob(ctx context.Context, subject string, fn func(ctx context.Context) error) *Job {
+	return &Job{
+		ID:      newJobID(),
+		Subject: subject,
+		Ctx:     ctx,
+		Fn:      fn,
+		status:  StatusPending,
+	}
+}
+
+func (j *Job) Status() Status {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.status
+}
+
+func (j *Job) Err() error {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.err
+}
+
+func (j *Job) setStatus(s Status) {
+	j.mu.Lock()
+	j.status = s
+	j.mu.Unlock()
+}
+
+func (j *Job) setErr(err error) {
+	j.mu.Lock()
+	j.err = err
+	j.mu.Unlock()
+}
+
+// Queue is a bounded FIFO job queue drained by a fixed-size worker pool.
+type Queue struct {
+	jobs    chan *Job
+	workers int
+
+	maxPerSubject int
+	subjectMu     sync.Mutex
+	subjectCounts map[string]int
+
+	registry sync.Map // id (string) -> *Job
+}
+
+// New starts a Queue with the given number of workers, a job channel
+// buffered to capacity, and maxPerSubject concurrent jobs allowed per
+// distinct Job.Subject (0 means unlimited).
+func New(workers, capacity, maxPerSubject int) *Queue {
+	if workers <= 0 {
+		workers = 1
+	}
+	if capacity <= 0 {
+		capacity = 1
+	}
+
+	q := &Queue{
+		jobs:          make(chan *Job, capacity),
+		workers:       workers,
+		maxPerSubject: maxPerSubject,
+		subjectCounts: make(map[string]int),
+	}
+
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+
+	return q
+}
+
+// Submit creates and enqueues a job running fn on behalf of subject,
+// returning the Job so the caller can hand its ID back to the client for
+// GET /jobs/{id} polling. It fails fast with ErrPerSubjectLimit or
+// ErrQueueFull rather than blocking.
+func (q *Queue) Submit(ctx context.Context, subject string, fn func(ctx context.Context) error) (*Job, error) {
+	if q.maxPerSubject > 0 {
+		q.subjectMu.Lock()
+		if q.subjectCounts[subject] >= q.maxPerSubject {
+			q.subjectMu.Unlock()
+			return nil, ErrPerSubjectLimit
+		}
+		q.subjectCounts[subject]++
+		q.subjectMu.Unlock()
+	}
+
+	job := newJob(ctx, subject, fn)
+
+	select {
+	case q.jobs <- job:
+		q.registry.Store(job.ID, job)
+		return job, nil
+	default:
+		q.releaseSubject(subject)
+		return nil, ErrQueueFull
+	}
+}
+
+// Job looks up a previously submitted job by ID.
+func (q *Queue) Job(id string) (*Job, bool) {
+	v, ok := q.registry.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return v.(*Job), true
+}
+
+func (q *Queue) work() {
+	for job := range q.jobs {
+		job.setStatus(StatusRunning)
+
+		if err := job.Fn(job.Ctx); err != nil {
+			job.setErr(err)
+			job.setStatus(StatusFailed)
+		} else {
+			job.setStatus(StatusDone)
+		}
+
+		q.releaseSubject(job.Subject)
+	}
+}
+
+func (q *Queue) releaseSubject(subject string) {
+	if q.maxPerSubject <= 0 {
+		return
+	}
+
+	q.subjectMu.Lock()
+	if q.subjectCounts[subject] > 0 {
+		q.subjectCounts[subject]--
+	}
+	q.subjectMu.Unlock()
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "job-fallback"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,121 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/arcsecc/lohpi/core/util"
+)
+
+// peerRequest is the POST/DELETE /admin/peers body, modeled after geth's
+// admin_addTrustedPeer/admin_removeTrustedPeer.
+type peerRequest struct {
+	Role string `json:"role"`
+	Addr string `json:"addr"`
+}
+
+// listPeers returns every currently registered policystore/directoryserver/
+// mux address.
+func (n *NodeCore) listPeers(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	b, err := json.Marshal(n.peers.List())
+	if err != nil {
+		log.Error(err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// addPeer trusts addr as the node's peer for the given role, replacing
+// whatever address was previously registered, then re-handshakes with it so
+// the peer learns this node's Ifrit address immediately rather than waiting
+// for the next gossip round. This is the only way to rotate a compromised
+// policystore or directoryserver, or drain traffic to a mux, without
+// restarting the node and losing in-flight checkouts.
+func (n *NodeCore) addPeer(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req peerRequest
+	if err := util.DecodeJSONBody(w, r, "application/json", &req); err != nil {
+		var e *util.MalformedParserReponse
+		if errors.As(err, &e) {
+			log.Infoln(err.Error())
+			http.Error(w, e.Msg, e.Status)
+		} else {
+			log.Infoln(err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if !validPeerRoles[req.Role] {
+		err := fmt.Errorf("unknown peer role '%s'", req.Role)
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Addr == "" {
+		err := errors.New("Missing peer address")
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n.peers.Set(req.Role, req.Addr)
+
+	if err := n.rehandshake(r.Context(), req.Role, req.Addr); err != nil {
+		// The new address is already registered, so subsequent RPCs will use
+		// it; only the best-effort immediate handshake failed, and it is not
+		// retried here.
+		log.Error(err.Error())
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removePeer drops the currently registered address for the given role.
+// The role and address to remove are both taken from the request body, so
+// a caller can't accidentally drop a peer it doesn't know the address of.
+func (n *NodeCore) removePeer(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req peerRequest
+	if err := util.DecodeJSONBody(w, r, "application/json", &req); err != nil {
+		var e *util.MalformedParserReponse
+		if errors.As(err, &e) {
+			log.Infoln(err.Error())
+			http.Error(w, e.Msg, e.Status)
+		} else {
+			log.Infoln(err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if !validPeerRoles[req.Role] {
+		err := fmt.Errorf("unknown peer role '%s'", req.Role)
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if current := n.peers.Get(req.Role); current == "" || current != req.Addr {
+		err := fmt.Errorf("no '%s' peer is registered at address '%s'", req.Role, req.Addr)
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound)+": "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	n.peers.Remove(req.Role)
+	w.WriteHeader(http.StatusNoContent)
+}
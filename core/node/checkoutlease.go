@@ -0,0 +1,125 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultCheckoutLeaseTTL is used when NodeWithCheckoutLeaseTTL is not
+// supplied, matching the TTL Minio uses for its refreshable locks.
+const defaultCheckoutLeaseTTL = time.Minute * 15
+
+// defaultCheckoutSweepInterval is how often the background sweeper goroutine
+// looks for expired leases.
+const defaultCheckoutSweepInterval = time.Minute
+
+// checkoutSubject resolves the identity a checkout/refresh/release request
+// should be attributed to, preferring the verified OIDC subject over the raw
+// bearer token, exactly as getDataset already does.
+func checkoutSubject(r *http.Request) (string, error) {
+	if subject := subjectFromContext(r.Context()); subject != "" {
+		return subject, nil
+	}
+
+	token, err := getBearerToken(r)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// refreshDatasetCheckout extends the caller's own lease on a dataset
+// checkout by another CheckoutLeaseTTL. It is idempotent: refreshing a lease
+// that hasn't expired yet simply pushes its expiry further out, and
+// refreshing one that has already been swept returns 404 so the client knows
+// to check the dataset out again.
+func (n *NodeCore) refreshDatasetCheckout(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	datasetId := mux.Vars(r)["id"]
+	if datasetId == "" {
+		err := errors.New("Missing dataset identifier")
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subject, err := checkoutSubject(r)
+	if err != nil {
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := n.dbRefreshCheckoutLease(subject, datasetId, n.checkoutLeaseTTL()); err != nil {
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound)+": "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// releaseDatasetCheckout lets a client voluntarily give up its checkout
+// before the lease expires, e.g. once a download completes successfully.
+func (n *NodeCore) releaseDatasetCheckout(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	datasetId := mux.Vars(r)["id"]
+	if datasetId == "" {
+		err := errors.New("Missing dataset identifier")
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subject, err := checkoutSubject(r)
+	if err != nil {
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := n.dbReleaseCheckoutLease(subject, datasetId); err != nil {
+		log.Infoln(err.Error())
+		http.Error(w, http.StatusText(http.StatusNotFound)+": "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkoutLeaseTTL returns the configured lease TTL, falling back to
+// defaultCheckoutLeaseTTL when NodeWithCheckoutLeaseTTL was never called.
+func (n *NodeCore) checkoutLeaseTTL() time.Duration {
+	if ttl := n.config().CheckoutLeaseTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultCheckoutLeaseTTL
+}
+
+// startCheckoutLeaseSweeper runs until stop is closed, periodically freeing
+// any checkout lease that has passed its expiry so a crashed or abandoned
+// download doesn't lock a client out of a dataset forever under
+// AllowMultipleCheckouts=false. It is started from NewNodeCore with no lease
+// state loaded beforehand; each tick calls dbSweepExpiredCheckoutLeases.
+func (n *NodeCore) startCheckoutLeaseSweeper(stop <-chan struct{}) {
+	ticker := time.NewTicker(defaultCheckoutSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := n.dbSweepExpiredCheckoutLeases(); err != nil {
+				log.Error(fmt.Sprintf("failed to sweep expired checkout leases: %s", err.Error()))
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package node
+
+import "time"
+
+// Event types fired onto the node's event bus. Each corresponds to a point
+// in the dataset lifecycle a webhook sink might want to react to.
+const (
+	EventDatasetIndexed        = "dataset.indexed"
+	EventDatasetCheckout       = "dataset.checkout"
+	EventDatasetCheckoutExpired = "dataset.checkout.expired"
+	EventDatasetPolicyChanged  = "dataset.policy.changed"
+	EventDatasetRemoved        = "dataset.removed"
+)
+
+// Event is the structured, JSON-serializable payload delivered to every
+// configured webhook sink.
+type Event struct {
+	Type      string                 `json:"type"`
+	DatasetId string                 `json:"datasetId"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// emitEvent publishes evt to every configured webhook sink. It is a no-op
+// when the node has no sinks configured, so deployments that never call
+// NodeWithWebhook or POST /admin/webhooks pay no cost.
+func (n *NodeCore) emitEvent(evt Event) {
+	n.eventBusMu.Lock()
+	bus := n.eventBus
+	n.eventBusMu.Unlock()
+
+	if bus == nil {
+		return
+	}
+	evt.Timestamp = time.Now()
+	bus.publish(evt)
+}
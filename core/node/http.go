@@ -2,8 +2,6 @@ package node
 
 import (
 	"bytes"
-	"bufio"
-	"io"
 	"strings"
 	"encoding/json"
 	"errors"
@@ -27,13 +25,26 @@ func (n *NodeCore) startHTTPServer(addr string) error {
 	dRouter := router.PathPrefix("/dataset").Schemes("HTTP").Subrouter()
 	dRouter.HandleFunc("/ids", n.getDatasetIdentifiers).Methods("GET")
 	dRouter.HandleFunc("/info/{id:.*}", n.getDatasetSummary).Methods("GET")
-	dRouter.HandleFunc("/new_policy/{id:.*}", n.setDatasetPolicy).Methods("PUT")
-	dRouter.HandleFunc("/data/{id:.*}", n.getDataset).Methods("GET")
-	dRouter.HandleFunc("/metadata/{id:.*}", n.getMetadata).Methods("GET")
-
-	// Middlewares used for validation
-	//dRouter.Use(n.middlewareValidateTokenSignature)
-	//dRouter.Use(n.middlewareValidateTokenClaims)
+	dRouter.HandleFunc("/checkout/{id:.*}/refresh", n.refreshDatasetCheckout).Methods("POST")
+	dRouter.HandleFunc("/checkout/{id:.*}", n.releaseDatasetCheckout).Methods("DELETE")
+
+	// datasetIDRouter groups exactly the routes that operate against a
+	// single dataset's policy or payload, each with an {id} path var the
+	// middlewares below resolve via mux.Vars(r)["id"]. /ids has no such var,
+	// so it stays on dRouter: applying the middleware there would resolve
+	// "id" to "" and reject every correctly scoped token.
+	datasetIDRouter := dRouter.NewRoute().Subrouter()
+	datasetIDRouter.HandleFunc("/new_policy/{id:.*}", n.setDatasetPolicy).Methods("PUT")
+	datasetIDRouter.HandleFunc("/data/{id:.*}", n.getDataset).Methods("GET", "HEAD")
+	datasetIDRouter.HandleFunc("/metadata/{id:.*}", n.getMetadata).Methods("GET", "HEAD")
+
+	// Middlewares used for validation. middlewareValidateTokenSignature is a
+	// no-op when no NodeWithOIDCProvider option was supplied, so existing
+	// deployments without an IdP configured are unaffected.
+	datasetIDRouter.Use(n.middlewareValidateTokenSignature)
+	datasetIDRouter.Use(n.middlewareValidateTokenClaims)
+
+	n.registerAdminRoutes(router)
 
 	handler := cors.AllowAll().Handler(router)
 
@@ -46,10 +57,8 @@ func (n *NodeCore) startHTTPServer(addr string) error {
 		TLSConfig:    comm.ServerConfig(n.cu.Certificate(), n.cu.CaCertificate(), n.cu.Priv()),
 	}
 
-	/*if err := m.setPublicKeyCache(); err != nil {
-		log.Errorln(err.Error())
-		return err
-	}*/
+	// JWKS key caches for any configured OIDC providers are primed by
+	// newOIDCAuthenticator during NewNodeCore; see oidcauth.go.
 
 	return n.httpServer.ListenAndServe()
 }
@@ -59,24 +68,6 @@ func redirectTLS(w http.ResponseWriter, r *http.Request) {
     //http.Redirect(w, r, "https://IPAddr:443"+r.RequestURI, http.StatusMovedPermanently)
 }
 
-/*func (n *NodeCore) setPublicKeyCache() error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	m.ar = jwk.NewAutoRefresh(ctx)
-	const msCerts = "https://login.microsoftonline.com/common/discovery/v2.0/keys" // TODO: config me
-
-	m.ar.Configure(msCerts, jwk.WithRefreshInterval(time.Minute * 5))
-
-	// Keep the cache warm
-	_, err := m.ar.Refresh(ctx, msCerts)
-	if err != nil {
-		log.Println("Failed to refresh Microsoft Azure JWKS")
-		return err
-	}
-	return nil
-}*/
-
 func (n *NodeCore) getMetadata(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	datasetId := strings.Split(r.URL.Path, "/dataset/metadata/")[1]
@@ -102,59 +93,11 @@ func (n *NodeCore) getMetadata(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	request, err := http.NewRequest("GET", dataset.GetMetadataURL(), nil)
-	if err != nil {
+	if err := streamUpstream(w, r, dataset.GetMetadataURL(), n.streamBufferSize()); err != nil {
 		log.Error(err.Error())
-		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	httpClient := &http.Client{
-		Timeout: time.Duration(20 * time.Second),
-	}
-
-	response, err := httpClient.Do(request)
-	if err != nil {
-		log.Error(err.Error())
-		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	defer response.Body.Close()
-	
-	if response.StatusCode != http.StatusOK {
-		log.Errorf("Response from remote data repository\n")
-		http.Error(w, http.StatusText(http.StatusInternalServerError) + ": " + "Could not fetch metadata from host.", http.StatusInternalServerError)
+		http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	bufferedReader := bufio.NewReader(response.Body)
-    buffer := make([]byte, 4 * 1024)
-
-	m := copyHeaders(response.Header)
-	setHeaders(m, w.Header())
-	w.WriteHeader(response.StatusCode)
-
-	for {
-    	len, err := bufferedReader.Read(buffer)
-        if len > 0 {	
-			_, err = w.Write(buffer[:len])
-			if err != nil {
-				log.Error(err.Error())
-			}
-		}
-
-        if err != nil {
-            if err == io.EOF {
-                log.Infoln(err.Error())
-            } else {
-				log.Error(err.Error())	
-				http.Error(w, http.StatusText(http.StatusInternalServerError) + ": " + err.Error(), http.StatusInternalServerError)
-				return
-			}
-            break
-        }
-    }
 }
 
 func copyHeaders(h map[string][]string) map[string][]string {
@@ -218,79 +161,75 @@ func (n *NodeCore) getDataset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := getBearerToken(r)
-	if err != nil {
-		log.Infoln(err.Error())
-		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+	// HEAD requests are a Content-Length probe, not a download, so they
+	// shouldn't consume a checkout lease.
+	if r.Method == http.MethodHead {
+		if err := streamUpstream(w, r, dataset.GetDatasetURL(), n.streamBufferSize()); err != nil {
+			log.Error(err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
+	// The verified subject attached by middlewareValidateTokenSignature takes
+	// priority over the raw bearer token, so checkouts are recorded against
+	// who the caller actually is rather than an opaque token value.
+	subject := subjectFromContext(r.Context())
+	if subject == "" {
+		token, err := getBearerToken(r)
+		if err != nil {
+			log.Infoln(err.Error())
+			http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		subject = string(token)
+	}
+
 	// Add dataset checkout to database. Rollback checkout if anything fails
-	if err := n.dbCheckoutDataset(string(token), datasetId); err != nil {
+	if err := n.dbCheckoutDataset(subject, datasetId); err != nil {
 		log.Error(err.Error())
 		http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	n.emitEvent(Event{
+		Type:      EventDatasetCheckout,
+		DatasetId: datasetId,
+		Data:      map[string]interface{}{"subject": subject},
+	})
+
 	if dataset.GetDatasetURL() == "" {
 		err := errors.New("Could not fetch dataset URL")
 		log.Error(err.Error())
+		n.rollbackCheckout(subject, datasetId)
 		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	request, err := http.NewRequest("GET", dataset.GetDatasetURL(), nil)
-	if err != nil {
+	// Forwarding the Range header upstream and streaming the response lets a
+	// client resume a multi-GB dataset download instead of restarting it from
+	// byte zero, and avoids buffering the whole body in memory either here or
+	// in bufferedReader's old 4 KiB loop.
+	if err := streamUpstream(w, r, dataset.GetDatasetURL(), n.streamBufferSize()); err != nil {
 		log.Error(err.Error())
-		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		// The stream broke partway through (or never started), so the client
+		// never received the dataset it checked out above. Without this the
+		// checkout would sit until its lease TTL expires, or forever under
+		// the old single-shot scheme.
+		n.rollbackCheckout(subject, datasetId)
+		http.Error(w, http.StatusText(http.StatusInternalServerError)+": "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+}
 
-	httpClient := &http.Client{
-		Timeout: time.Duration(20 * time.Second),
-	}
-
-	response, err := httpClient.Do(request)
-	if err != nil {
+// rollbackCheckout releases a checkout lease granted earlier in getDataset
+// once it's clear the client will never receive the dataset it was granted
+// for, logging rather than surfacing the release error since a response has
+// already been (or is about to be) written to the caller.
+func (n *NodeCore) rollbackCheckout(subject, datasetId string) {
+	if err := n.dbReleaseCheckoutLease(subject, datasetId); err != nil {
 		log.Error(err.Error())
-		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
-		return
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusOK {
-		log.Errorf("Response from remote data repository\n")
-		http.Error(w, http.StatusText(http.StatusInternalServerError) + ": " + "Could not fetch dataset from host.", http.StatusInternalServerError)
-		return
 	}
-
-	bufferedReader := bufio.NewReader(response.Body)
-    buffer := make([]byte, 4 * 1024)
-
-	m := copyHeaders(response.Header)
-	setHeaders(m, w.Header())
-	w.WriteHeader(response.StatusCode)
-
-	for {
-    	len, err := bufferedReader.Read(buffer)
-        if len > 0 {	
-			_, err = w.Write(buffer[:len])
-			if err != nil {
-				log.Error(err.Error())
-			}
-		}
-
-        if err != nil {
-            if err == io.EOF {
-                log.Infoln(err.Error())
-            } else {
-				log.Error(err.Error())	
-				http.Error(w, http.StatusText(http.StatusInternalServerError) + ": " + err.Error(), http.StatusInternalServerError)
-				return
-			}
-            break
-        }
-    }
 }
 
 // Returns the dataset identifiers stored at this node
@@ -440,6 +379,12 @@ func (n *NodeCore) setDatasetPolicy(w http.ResponseWriter, r *http.Request) {
 		log.Warnln(err.Error())
 	}
 
+	n.emitEvent(Event{
+		Type:      EventDatasetPolicyChanged,
+		DatasetId: dataset,
+		Data:      map[string]interface{}{"policy": reqBody.Policy},
+	})
+
 	respMsg := "Successfully set a new policy for " + dataset + "\n"
 	w.WriteHeader(http.StatusCreated)
 	w.Header().Set("Content-Type", "application/text")
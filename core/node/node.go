@@ -0,0 +1,209 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/arcsecc/lohpi/core/comm"
+)
+
+// Config holds every setting a lohpi.Node can be constructed with via
+// lohpi.NodeOption. See the NodeWith* constructors in the top-level package
+// for the meaning of each field.
+type Config struct {
+	HTTPPort  int
+	HTTPSPort int
+
+	LohpiCaAddress string
+	LohpiCaPort    int
+
+	PolicyStoreAddress   string
+	PolicyStoreGRPCPport int
+
+	DirectoryServerAddress string
+	DirectoryServerGPRCPort int
+
+	Name string
+
+	PostgresSQLConnectionString string
+	DatabaseRetentionInterval   time.Duration
+
+	AllowMultipleCheckouts bool
+	DebugEnabled           bool
+	TLSEnabled             bool
+
+	HostName string
+
+	// CheckoutLeaseTTL is how long a dataset checkout lease lasts before the
+	// background sweeper frees it. Set via NodeWithCheckoutLeaseTTL.
+	CheckoutLeaseTTL time.Duration
+
+	// OIDCProviders lists every trusted OIDC identity provider, set via
+	// NodeWithOIDCProvider. Empty means dataset endpoints skip token
+	// signature/claim validation entirely.
+	OIDCProviders []OIDCProviderConfig
+
+	// WebhookSinks lists every webhook registered via NodeWithWebhook at
+	// construction time. More sinks can be added later through
+	// POST /admin/webhooks.
+	WebhookSinks []WebhookSinkConfig
+
+	// StreamBufferSize is the io.CopyBuffer buffer size used when streaming
+	// dataset/metadata responses. Set via NodeWithStreamBufferSize.
+	StreamBufferSize int
+}
+
+// NodeCore is the lower-level node lohpi.Node wraps. It owns the HTTP server,
+// the node's certificate material and every piece of runtime state that can
+// be rotated without a restart (OIDC providers are the exception: those are
+// only read at construction time).
+type NodeCore struct {
+	conf *Config
+
+	cu *comm.CryptoUnit
+
+	httpServer *http.Server
+
+	// oidcAuth verifies bearer tokens against conf.OIDCProviders. It is nil
+	// when no provider was configured, in which case
+	// middlewareValidateTokenSignature lets every request through unchecked.
+	oidcAuth *oidcAuthenticator
+
+	// eventBus fans dataset lifecycle events out to every configured webhook
+	// sink. eventBusMu guards its lazy initialization: addWebhookSink can run
+	// concurrently with itself across multiple POST /admin/webhooks calls,
+	// and with emitEvent on every request handler.
+	eventBusMu sync.Mutex
+	eventBus   *eventBus
+
+	// peers is the single source of truth for the policystore/directoryserver
+	// addresses this node talks to. JoinNetwork reads from it rather than
+	// from conf directly, so a rotation via POST/DELETE /admin/peers is
+	// picked up the next time this node (re-)dials a peer without a restart.
+	peers *peerRegistry
+
+	stop chan struct{}
+}
+
+// config returns n's configuration. It exists so the rest of the package
+// reads n.config().Field instead of reaching into n.conf directly.
+func (n *NodeCore) config() *Config {
+	return n.conf
+}
+
+// NewNodeCore builds a NodeCore from conf, priming the OIDC JWKS caches for
+// every configured provider before returning so the first incoming request
+// doesn't pay that latency.
+func NewNodeCore(conf *Config) (*NodeCore, error) {
+	if conf == nil {
+		return nil, fmt.Errorf("node: config must not be nil")
+	}
+
+	// The crypto unit backs both JoinNetwork's outgoing dials (peers.go) and
+	// the TLSConfig startHTTPServer sets on its server (http.go), so it must exist before
+	// either runs. There's no Lohpi CA client in this tree yet, so nodes
+	// self-sign; see comm.NewSelfSignedCryptoUnit.
+	cu, err := comm.NewSelfSignedCryptoUnit(conf.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize crypto unit: %w", err)
+	}
+
+	n := &NodeCore{
+		conf: conf,
+		cu:   cu,
+		peers: newPeerRegistry(map[string]string{
+			RolePolicyStore:     fmt.Sprintf("%s:%d", conf.PolicyStoreAddress, conf.PolicyStoreGRPCPport),
+			RoleDirectoryServer: fmt.Sprintf("%s:%d", conf.DirectoryServerAddress, conf.DirectoryServerGPRCPort),
+		}),
+		stop: make(chan struct{}),
+	}
+
+	if len(conf.OIDCProviders) > 0 {
+		oidcAuth, err := newOIDCAuthenticator(context.Background(), conf.OIDCProviders)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize OIDC authenticator: %w", err)
+		}
+		n.oidcAuth = oidcAuth
+	}
+
+	if len(conf.WebhookSinks) > 0 {
+		n.eventBus = newEventBus(conf.WebhookSinks)
+	}
+
+	// Started here; nothing loads the lease table beforehand, and each tick
+	// of the sweeper calls dbSweepExpiredCheckoutLeases.
+	go n.startCheckoutLeaseSweeper(n.stop)
+
+	return n, nil
+}
+
+// JoinNetwork re-handshakes with the policystore and directory server
+// currently registered in n.peers, then starts the HTTP server clients talk
+// to. Reading from n.peers instead of n.conf directly means a peer rotated
+// via POST/DELETE /admin/peers after this node already joined is what the
+// next reconnect attempt uses, not the address it was started with.
+func (n *NodeCore) JoinNetwork() error {
+	ctx := context.Background()
+
+	if addr := n.peers.Get(RolePolicyStore); addr != "" {
+		if err := n.rehandshake(ctx, RolePolicyStore, addr); err != nil {
+			return fmt.Errorf("failed to join network: %w", err)
+		}
+	}
+
+	if addr := n.peers.Get(RoleDirectoryServer); addr != "" {
+		if err := n.rehandshake(ctx, RoleDirectoryServer, addr); err != nil {
+			return fmt.Errorf("failed to join network: %w", err)
+		}
+	}
+
+	return n.startHTTPServer(fmt.Sprintf("%s:%d", n.conf.HostName, n.conf.HTTPSPort))
+}
+
+// Shutdown stops the HTTP server and any background goroutines started by
+// NewNodeCore.
+func (n *NodeCore) Shutdown() {
+	close(n.stop)
+	if n.httpServer != nil {
+		n.httpServer.Close()
+	}
+}
+
+// IfritAddress returns the address other members of the network use to
+// gossip with this node.
+func (n *NodeCore) IfritAddress() string {
+	return n.conf.HostName
+}
+
+// IndexDataset stores a new dataset/metadata URL pair under datasetId and
+// notifies any configured webhook sink that it's now available.
+func (n *NodeCore) IndexDataset(datasetId, datasetURL, metadataURL string) error {
+	if err := n.dbIndexDataset(datasetId, datasetURL, metadataURL); err != nil {
+		return err
+	}
+
+	n.emitEvent(Event{
+		Type:      EventDatasetIndexed,
+		DatasetId: datasetId,
+	})
+
+	return nil
+}
+
+// RemoveDataset drops datasetId so it's no longer served to clients, and
+// notifies any configured webhook sink that it's gone.
+func (n *NodeCore) RemoveDataset(datasetId string) error {
+	if err := n.dbRemoveDataset(datasetId); err != nil {
+		return err
+	}
+
+	n.emitEvent(Event{
+		Type:      EventDatasetRemoved,
+		DatasetId: datasetId,
+	})
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+	log "github.com/sirupsen/logrus"
+)
+
+// OIDCProviderConfig describes a single trusted OIDC identity provider. A
+// node may trust more than one provider at once (e.g. during a migration
+// between IdPs), which is why Config.OIDCProviders is a slice.
+type OIDCProviderConfig struct {
+	// IssuerURL is the provider's issuer, e.g. "https://login.microsoftonline.com/<tenant>/v2.0".
+	// The JWKS URI is discovered from <IssuerURL>/.well-known/openid-configuration.
+	IssuerURL string
+
+	// Audience is the expected "aud" claim.
+	Audience string
+
+	// ClientID is the expected authorized party, checked against the "azp" claim when present.
+	ClientID string
+
+	// JWKSRefreshInterval controls how often the key set is re-fetched. Defaults to 5 minutes.
+	JWKSRefreshInterval time.Duration
+}
+
+// datasetScopeClaim is the custom claim this node requires on every token,
+// mapping the caller's granted scope to the dataset identifier in the URL.
+const datasetScopeClaim = "dataset_scope"
+
+// oidcVerifier validates bearer tokens against one trusted OIDC provider's
+// auto-refreshing JWKS.
+type oidcVerifier struct {
+	config   OIDCProviderConfig
+	jwksURI  string
+	autoRefr *jwk.AutoRefresh
+}
+
+// newOIDCVerifier discovers cfg's JWKS URI from its issuer's OpenID
+// Connect discovery document and starts an auto-refreshing key cache.
+func newOIDCVerifier(ctx context.Context, cfg OIDCProviderConfig) (*oidcVerifier, error) {
+	jwksURI, err := discoverJWKSURI(cfg.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := cfg.JWKSRefreshInterval
+	if refresh <= 0 {
+		refresh = time.Minute * 5
+	}
+
+	ar := jwk.NewAutoRefresh(ctx)
+	ar.Configure(jwksURI, jwk.WithRefreshInterval(refresh))
+
+	if _, err := ar.Refresh(ctx, jwksURI); err != nil {
+		return nil, fmt.Errorf("failed to prime JWKS for issuer '%s': %w", cfg.IssuerURL, err)
+	}
+
+	return &oidcVerifier{
+		config:   cfg,
+		jwksURI:  jwksURI,
+		autoRefr: ar,
+	}, nil
+}
+
+// discoverJWKSURI fetches <issuerURL>/.well-known/openid-configuration and
+// extracts its jwks_uri field.
+func discoverJWKSURI(issuerURL string) (string, error) {
+	discoveryURL := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+
+	resp, err := http.Get(discoveryURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("discovery endpoint '%s' returned status %d", discoveryURL, resp.StatusCode)
+	}
+
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", err
+	}
+
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("discovery document at '%s' has no jwks_uri", discoveryURL)
+	}
+
+	return doc.JWKSURI, nil
+}
+
+// verify parses and validates a raw JWT against this provider's keys and
+// the claims required by this node, returning the verified subject.
+func (v *oidcVerifier) verify(ctx context.Context, rawToken string, datasetId string) (string, error) {
+	keySet, err := v.autoRefr.Fetch(ctx, v.jwksURI)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	token, err := jwt.ParseString(rawToken,
+		jwt.WithKeySet(keySet),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(v.config.IssuerURL),
+		jwt.WithAudience(v.config.Audience),
+	)
+	if err != nil {
+		return "", fmt.Errorf("token validation failed: %w", err)
+	}
+
+	if v.config.ClientID != "" {
+		if azp, ok := token.Get("azp"); ok && fmt.Sprintf("%v", azp) != v.config.ClientID {
+			return "", fmt.Errorf("token's 'azp' claim does not match client id '%s'", v.config.ClientID)
+		}
+	}
+
+	scope, ok := token.Get(datasetScopeClaim)
+	if !ok {
+		return "", fmt.Errorf("token is missing required '%s' claim", datasetScopeClaim)
+	}
+
+	if fmt.Sprintf("%v", scope) != datasetId {
+		return "", fmt.Errorf("token's '%s' claim does not grant access to dataset '%s'", datasetScopeClaim, datasetId)
+	}
+
+	return token.Subject(), nil
+}
+
+// oidcAuthenticator holds every trusted provider configured via
+// NodeWithOIDCProvider, tried in order until one accepts the token.
+type oidcAuthenticator struct {
+	verifiers []*oidcVerifier
+}
+
+func newOIDCAuthenticator(ctx context.Context, providers []OIDCProviderConfig) (*oidcAuthenticator, error) {
+	a := &oidcAuthenticator{}
+	for _, p := range providers {
+		v, err := newOIDCVerifier(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		a.verifiers = append(a.verifiers, v)
+	}
+	return a, nil
+}
+
+func (a *oidcAuthenticator) verify(ctx context.Context, rawToken, datasetId string) (string, error) {
+	var lastErr error
+	for _, v := range a.verifiers {
+		subject, err := v.verify(ctx, rawToken, datasetId)
+		if err == nil {
+			return subject, nil
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no OIDC provider configured")
+	}
+	return "", lastErr
+}
+
+// subjectContextKey is the context key the verified token subject is stored
+// under, so downstream calls like dbCheckoutDataset record who actually made
+// the request instead of the raw bearer token.
+type subjectContextKey struct{}
+
+func contextWithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectContextKey{}, subject)
+}
+
+// subjectFromContext returns the verified subject attached by
+// middlewareValidateTokenSignature, or "" if
+// the node has no OIDC provider configured and the request was let through.
+func subjectFromContext(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectContextKey{}).(string)
+	return subject
+}
+
+// middlewareValidateTokenSignature rejects requests whose bearer token does
+// not verify against any trusted OIDC provider's JWKS. Requests to datasets
+// are tagged with the dataset id they're scoped to via the mux path, which
+// is why this must run as a subrouter middleware rather than once globally.
+func (n *NodeCore) middlewareValidateTokenSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.oidcAuth == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		datasetId := mux.Vars(r)["id"]
+
+		token, err := getBearerToken(r)
+		if err != nil {
+			log.Infoln(err.Error())
+			http.Error(w, http.StatusText(http.StatusUnauthorized)+": "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		subject, err := n.oidcAuth.verify(r.Context(), string(token), datasetId)
+		if err != nil {
+			log.Infoln(err.Error())
+			http.Error(w, http.StatusText(http.StatusUnauthorized)+": "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(contextWithSubject(r.Context(), subject)))
+	})
+}
+
+// middlewareValidateTokenClaims is kept as a distinct, no-op pass-through
+// stage so additional claim checks (beyond iss/aud/exp/nbf/dataset_scope,
+// which middlewareValidateTokenSignature already enforces) can be added
+// without having to touch the signature-verification stage.
+func (n *NodeCore) middlewareValidateTokenClaims(next http.Handler) http.Handler {
+	return next
+}
+
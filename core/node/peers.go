@@ -0,0 +1,135 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/arcsecc/lohpi/core/comm"
+	pb "github.com/arcsecc/lohpi/protobuf"
+)
+
+// Peer roles accepted by POST/DELETE /admin/peers.
+const (
+	RolePolicyStore     = "policystore"
+	RoleDirectoryServer = "directoryserver"
+	RoleMux              = "mux"
+)
+
+// validPeerRoles is used to validate incoming admin requests without
+// allocating a new set on every call.
+var validPeerRoles = map[string]bool{
+	RolePolicyStore:     true,
+	RoleDirectoryServer: true,
+	RoleMux:              true,
+}
+
+// peerRegistry holds the current policystore/directoryserver/mux addresses
+// behind an atomic.Value, so JoinNetwork can read the live address without
+// taking a lock, while POST/DELETE /admin/peers can rotate it without
+// restarting the node or disturbing in-flight checkouts. addPeer also
+// re-handshakes with the new address immediately, so a rotation takes
+// effect right away rather than waiting for this node's next reconnect.
+type peerRegistry struct {
+	addrs atomic.Value // map[string]string
+}
+
+func newPeerRegistry(initial map[string]string) *peerRegistry {
+	snapshot := make(map[string]string, len(initial))
+	for role, addr := range initial {
+		snapshot[role] = addr
+	}
+
+	r := &peerRegistry{}
+	r.addrs.Store(snapshot)
+	return r
+}
+
+// Get returns the current address registered for role, or "" if none is set.
+func (r *peerRegistry) Get(role string) string {
+	return r.snapshot()[role]
+}
+
+// Set replaces the address registered for role. The swap is copy-on-write so
+// concurrent readers never observe a partially updated map.
+func (r *peerRegistry) Set(role, addr string) {
+	current := r.snapshot()
+	next := make(map[string]string, len(current)+1)
+	for k, v := range current {
+		next[k] = v
+	}
+	next[role] = addr
+	r.addrs.Store(next)
+}
+
+// Remove drops the address registered for role, if any.
+func (r *peerRegistry) Remove(role string) {
+	current := r.snapshot()
+	if _, ok := current[role]; !ok {
+		return
+	}
+
+	next := make(map[string]string, len(current))
+	for k, v := range current {
+		if k != role {
+			next[k] = v
+		}
+	}
+	r.addrs.Store(next)
+}
+
+// List returns a copy of every registered role/address pair.
+func (r *peerRegistry) List() map[string]string {
+	current := r.snapshot()
+	out := make(map[string]string, len(current))
+	for k, v := range current {
+		out[k] = v
+	}
+	return out
+}
+
+func (r *peerRegistry) snapshot() map[string]string {
+	m, _ := r.addrs.Load().(map[string]string)
+	return m
+}
+
+// rehandshake re-registers this node's Ifrit address with the peer at addr,
+// so a rotated policystore or directoryserver learns about this node
+// immediately instead of waiting for the next periodic gossip round.
+// Mux peers aren't handshaken with since the mux doesn't track individual
+// nodes the way the directory server and policy store do.
+func (n *NodeCore) rehandshake(ctx context.Context, role, addr string) error {
+	if role == RoleMux {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, comm.ClientConfig(n.cu.Certificate(), n.cu.CaCertificate(), n.cu.Priv())...)
+	if err != nil {
+		return fmt.Errorf("failed to dial peer '%s' at '%s': %w", role, addr, err)
+	}
+	defer conn.Close()
+
+	self := &pb.Node{
+		Name:         n.config().Name,
+		IfritAddress: n.IfritAddress(),
+	}
+
+	switch role {
+	case RolePolicyStore:
+		_, err = pb.NewPolicyStoreClient(conn).Handshake(ctx, self)
+	case RoleDirectoryServer:
+		_, err = pb.NewDirectoryServerClient(conn).Handshake(ctx, self)
+	}
+
+	if err != nil {
+		return fmt.Errorf("handshake with peer '%s' at '%s' failed: %w", role, addr, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package node
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultStreamBufferSize is used when NodeWithStreamBufferSize is not
+// supplied. It matches io.Copy's own default buffer size.
+const defaultStreamBufferSize = 32 * 1024
+
+// streamBufferSize returns the configured io.CopyBuffer buffer size, falling
+// back to defaultStreamBufferSize.
+func (n *NodeCore) streamBufferSize() int {
+	if size := n.config().StreamBufferSize; size > 0 {
+		return size
+	}
+	return defaultStreamBufferSize
+}
+
+// streamUpstream proxies r to upstreamURL and copies the response to w,
+// honoring Range requests and HEAD so large datasets can be resumed instead
+// of re-downloaded from scratch, and so clients can probe Content-Length
+// without pulling the body at all.
+func streamUpstream(w http.ResponseWriter, r *http.Request, upstreamURL string, bufSize int) error {
+	if upstreamURL == "" {
+		return errors.New("upstream URL is empty")
+	}
+
+	method := "GET"
+	if r.Method == http.MethodHead {
+		method = "HEAD"
+	}
+
+	request, err := http.NewRequest(method, upstreamURL, nil)
+	if err != nil {
+		return err
+	}
+
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		request.Header.Set("Range", rangeHeader)
+	}
+
+	httpClient := &http.Client{
+		Timeout: time.Minute * 5,
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent {
+		return errors.New("upstream returned status " + strconv.Itoa(response.StatusCode))
+	}
+
+	m := copyHeaders(response.Header)
+	setHeaders(m, w.Header())
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.WriteHeader(response.StatusCode)
+
+	if method == "HEAD" {
+		return nil
+	}
+
+	buffer := make([]byte, bufSize)
+	if _, err := io.CopyBuffer(w, response.Body, buffer); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
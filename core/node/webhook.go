@@ -0,0 +1,272 @@
+package node
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/arcsecc/lohpi/core/util"
+)
+
+// webhookQueueDropped counts events dropped from a sink's queue because it
+// was full, so operators can tell a SIEM integration is falling behind
+// before it silently misses events.
+var webhookQueueDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "lohpi",
+	Subsystem: "node",
+	Name:      "webhook_queue_dropped_total",
+	Help:      "Events dropped from a webhook sink's queue because it was full.",
+})
+
+func init() {
+	prometheus.MustRegister(webhookQueueDropped)
+}
+
+const (
+	defaultWebhookQueueSize  = 256
+	defaultWebhookMaxRetries = 5
+	defaultWebhookBackoff    = time.Second
+)
+
+// WebhookSinkConfig describes a single webhook target, as built by
+// lohpi.NodeWithWebhook/lohpi.WebhookWith* options or decoded from a
+// POST /admin/webhooks request body.
+type WebhookSinkConfig struct {
+	Target *url.URL
+
+	// AuthToken, when set, is sent as "Authorization: Splunk <token>" on
+	// every delivery, matching the header Splunk HEC expects.
+	AuthToken string
+
+	// HMACSecret, when set, is used to sign the JSON body with HMAC-SHA256;
+	// the hex-encoded signature is sent as X-Lohpi-Signature so the
+	// receiving SIEM can verify the event actually came from this node.
+	HMACSecret []byte
+
+	// QueueSize bounds how many undelivered events this sink buffers before
+	// it starts dropping the oldest one. Defaults to defaultWebhookQueueSize.
+	QueueSize int
+
+	// MaxRetries bounds the exponential backoff retry loop per event.
+	// Defaults to defaultWebhookMaxRetries.
+	MaxRetries int
+}
+
+// webhookSink is the running counterpart of a WebhookSinkConfig: a bounded
+// queue drained by a single dispatcher goroutine, so a slow or unreachable
+// sink can't block the handler that emitted the event.
+type webhookSink struct {
+	config WebhookSinkConfig
+	client *http.Client
+	queue  chan Event
+	stop   chan struct{}
+}
+
+func newWebhookSink(cfg WebhookSinkConfig) *webhookSink {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultWebhookQueueSize
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultWebhookMaxRetries
+	}
+
+	s := &webhookSink{
+		config: cfg,
+		client: &http.Client{Timeout: time.Second * 10},
+		queue:  make(chan Event, cfg.QueueSize),
+		stop:   make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// enqueue drops the oldest queued event to make room when the sink's queue
+// is full, so a stalled sink degrades to "missing old events" rather than
+// blocking whatever goroutine is emitting them.
+func (s *webhookSink) enqueue(evt Event) {
+	select {
+	case s.queue <- evt:
+		return
+	default:
+	}
+
+	select {
+	case <-s.queue:
+	default:
+	}
+
+	select {
+	case s.queue <- evt:
+	default:
+	}
+
+	webhookQueueDropped.Inc()
+}
+
+func (s *webhookSink) run() {
+	for {
+		select {
+		case evt := <-s.queue:
+			s.deliver(evt)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// deliver POSTs evt to the sink's target, retrying with exponential backoff
+// up to config.MaxRetries times before giving up on the event.
+func (s *webhookSink) deliver(evt Event) {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	backoff := defaultWebhookBackoff
+	for attempt := 0; attempt <= s.config.MaxRetries; attempt++ {
+		req, err := http.NewRequest("POST", s.config.Target.String(), bytes.NewReader(body))
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		if s.config.AuthToken != "" {
+			req.Header.Set("Authorization", "Splunk "+s.config.AuthToken)
+		}
+
+		if len(s.config.HMACSecret) > 0 {
+			mac := hmac.New(sha256.New, s.config.HMACSecret)
+			mac.Write(body)
+			req.Header.Set("X-Lohpi-Signature", hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		resp, err := s.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("webhook target '%s' returned status %d", s.config.Target, resp.StatusCode)
+		}
+
+		log.Infoln(fmt.Sprintf("webhook delivery attempt %d/%d failed: %s", attempt+1, s.config.MaxRetries+1, err.Error()))
+
+		if attempt < s.config.MaxRetries {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	log.Error(fmt.Sprintf("giving up on event '%s' for webhook target '%s' after %d attempts", evt.Type, s.config.Target, s.config.MaxRetries+1))
+}
+
+// eventBus fans dataset lifecycle events out to every configured webhook
+// sink. Sinks can be added at construction time (NodeWithWebhook) or at
+// runtime (POST /admin/webhooks); both paths go through addSink so neither
+// has to duplicate the defaulting/goroutine-spawning logic.
+type eventBus struct {
+	mu    sync.RWMutex
+	sinks []*webhookSink
+}
+
+func newEventBus(configs []WebhookSinkConfig) *eventBus {
+	b := &eventBus{}
+	for _, cfg := range configs {
+		b.addSink(cfg)
+	}
+	return b
+}
+
+func (b *eventBus) addSink(cfg WebhookSinkConfig) {
+	sink := newWebhookSink(cfg)
+
+	b.mu.Lock()
+	b.sinks = append(b.sinks, sink)
+	b.mu.Unlock()
+}
+
+func (b *eventBus) publish(evt Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, sink := range b.sinks {
+		sink.enqueue(evt)
+	}
+}
+
+// addWebhookSink lets operators plug a SIEM or other listener into a
+// running node without a restart.
+func (n *NodeCore) addWebhookSink(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var reqBody struct {
+		Target     string `json:"target"`
+		AuthToken  string `json:"authToken"`
+		HMACSecret string `json:"hmacSecret"`
+		QueueSize  int    `json:"queueSize"`
+		MaxRetries int    `json:"maxRetries"`
+	}
+
+	if err := util.DecodeJSONBody(w, r, "application/json", &reqBody); err != nil {
+		var e *util.MalformedParserReponse
+		if errors.As(err, &e) {
+			log.Infoln(err.Error())
+			http.Error(w, e.Msg, e.Status)
+		} else {
+			log.Infoln(err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	target, err := url.Parse(reqBody.Target)
+	if err != nil || reqBody.Target == "" {
+		log.Infoln("invalid webhook target")
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": invalid webhook target", http.StatusBadRequest)
+		return
+	}
+
+	n.eventBusMu.Lock()
+	if n.eventBus == nil {
+		n.eventBus = newEventBus(nil)
+	}
+	bus := n.eventBus
+	n.eventBusMu.Unlock()
+
+	bus.addSink(WebhookSinkConfig{
+		Target:     target,
+		AuthToken:  reqBody.AuthToken,
+		HMACSecret: []byte(reqBody.HMACSecret),
+		QueueSize:  reqBody.QueueSize,
+		MaxRetries: reqBody.MaxRetries,
+	})
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// registerAdminRoutes mounts the runtime administration endpoints. It is
+// kept separate from the /dataset routes in startHTTPServer since admin
+// endpoints are not (yet) behind the OIDC middleware dataset access uses.
+func (n *NodeCore) registerAdminRoutes(router *mux.Router) {
+	aRouter := router.PathPrefix("/admin").Subrouter()
+	aRouter.HandleFunc("/webhooks", n.addWebhookSink).Methods("POST")
+	aRouter.HandleFunc("/peers", n.listPeers).Methods("GET")
+	aRouter.HandleFunc("/peers", n.addPeer).Methods("POST")
+	aRouter.HandleFunc("/peers", n.removePeer).Methods("DELETE")
+}
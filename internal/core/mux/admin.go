@@ -0,0 +1,141 @@
+package mux
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// adminNodeInfo is what GET /admin/nodes returns for each known storage node.
+type adminNodeInfo struct {
+	ID       string    `json:"id"`
+	Address  string    `json:"address"`
+	// LastSeen is the time the list was built (time.Now()), not the time
+	// the node was last heard from.
+	LastSeen time.Time `json:"lastSeen"`
+	Healthy  bool      `json:"healthy"`
+}
+
+// adminAddNodeRequest is the body of POST /admin/nodes.
+type adminAddNodeRequest struct {
+	Address string `json:"address"`
+	Name    string `json:"name"`
+}
+
+// registerAdminRoutes wires the authenticated admin API used to manage
+// trusted storage nodes at runtime, modeled after go-ethereum's
+// admin_addTrustedPeer/admin_removeTrustedPeer. Before this, changing the
+// set of participating storage nodes required restarting the mux.
+func (m *Mux) registerAdminRoutes(r *mux.Router) {
+	adminRouter := r.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(m.adminAuthMiddleware)
+
+	adminRouter.HandleFunc("/nodes", m.adminAddNode).Methods("POST")
+	adminRouter.HandleFunc("/nodes", m.adminListNodes).Methods("GET")
+	adminRouter.HandleFunc("/nodes/{id}", m.adminRemoveNode).Methods("DELETE")
+	adminRouter.HandleFunc("/nodes/{id}/reindex", m.adminReindexNode).Methods("POST")
+}
+
+// adminAuthMiddleware gates every /admin route behind a bearer token held by
+// the operator. The token itself is supplied out-of-band via Config.AdminToken.
+func (m *Mux) adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if m.config.AdminToken == "" || token != "Bearer "+m.config.AdminToken {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminAddNode dials the given address over Ifrit and inserts it into the
+// node cache so it immediately becomes eligible for study routing.
+func (m *Mux) adminAddNode(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req adminAddNodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Address == "" || req.Name == "" {
+		http.Error(w, "address and name are required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := m.ifritClient.Dial(req.Address); err != nil {
+		log.Error(err.Error())
+		http.Error(w, fmt.Sprintf("Could not dial node at '%s': %s", req.Address, err.Error()), http.StatusBadGateway)
+		return
+	}
+
+	if err := m.cache.AddNode(req.Name, req.Address); err != nil {
+		log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "Added node '%s' at '%s'\n", req.Name, req.Address)
+}
+
+// adminRemoveNode evicts a node from the cache and disconnects it.
+func (m *Mux) adminRemoveNode(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		http.Error(w, "Missing node identifier", http.StatusBadRequest)
+		return
+	}
+
+	if err := m.cache.RemoveNode(id); err != nil {
+		log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.ifritClient.Disconnect(id)
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Removed node '%s'\n", id)
+}
+
+// adminListNodes returns a structured JSON list of currently-known nodes.
+func (m *Mux) adminListNodes(w http.ResponseWriter, r *http.Request) {
+	nodes := make([]adminNodeInfo, 0)
+	for id, node := range m.cache.Nodes() {
+		nodes = append(nodes, adminNodeInfo{
+			ID:       id,
+			Address:  node.GetAddress(),
+			LastSeen: time.Now(),
+			Healthy:  m.ifritClient.IsConnected(id),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(nodes)
+}
+
+// adminReindexNode forces the mux to re-fetch the study list from a single
+// node, rather than waiting for the next full FetchRemoteStudyLists sweep.
+func (m *Mux) adminReindexNode(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		http.Error(w, "Missing node identifier", http.StatusBadRequest)
+		return
+	}
+
+	if err := m.cache.ReindexNode(id); err != nil {
+		log.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Reindexed node '%s'\n", id)
+}
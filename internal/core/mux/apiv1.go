@@ -0,0 +1,247 @@
+package mux
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/tomcat-bit/lohpi/internal/core/message"
+
+	"github.com/gorilla/mux"
+)
+
+// networkInfo is the structured, JSON counterpart of the plain-text output
+// the legacy /network endpoint has always produced.
+type networkInfo struct {
+	HTTPPort     int                `json:"httpPort"`
+	GRPCAddress  string             `json:"grpcAddress"`
+	IfritAddress string             `json:"ifritAddress"`
+	Nodes        []networkNodeInfo  `json:"nodes"`
+	Studies      []networkStudyInfo `json:"studies"`
+}
+
+type networkNodeInfo struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+type networkStudyInfo struct {
+	Name string `json:"name"`
+	Node string `json:"node"`
+}
+
+// studyMetadataResponse is returned by GET /api/v1/studies/{study}/metadata.
+type studyMetadataResponse struct {
+	Study    string `json:"study"`
+	Node     string `json:"node"`
+	Metadata string `json:"metadata"`
+}
+
+// studyDataResponse is returned by POST /api/v1/studies/{study}/data.
+type studyDataResponse struct {
+	Study string `json:"study"`
+	Node  string `json:"node"`
+	Data  string `json:"data"`
+}
+
+// apiError is the JSON error envelope used by every /api/v1 handler.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// registerAPIv1Routes mounts a versioned JSON REST API at /api/v1. Unlike
+// the legacy handlers in http.go, every response here is application/json with
+// proper HTTP status codes, and request bodies are validated before use.
+func (m *Mux) registerAPIv1Routes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+
+	api.HandleFunc("/network", m.apiNetwork).Methods("GET")
+	api.HandleFunc("/nodes", m.apiListNodes).Methods("GET")
+	api.HandleFunc("/studies/{study}/metadata", m.apiStudyMetadata).Methods("GET")
+	api.HandleFunc("/studies/{study}/data", m.apiStudyData).Methods("POST")
+	api.HandleFunc("/openapi.json", m.apiOpenAPISpec).Methods("GET")
+}
+
+// wantsJSON reports whether the caller explicitly asked for JSON, allowing
+// legacy text/plain endpoints to serve their /api/v1 JSON equivalent during
+// the deprecation period instead of forcing an immediate client rewrite.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// networkSnapshot assembles the current network view shared by the legacy
+// text/plain /network handler and its JSON /api/v1/network counterpart.
+func (m *Mux) networkSnapshot() networkInfo {
+	m.cache.FetchRemoteStudyLists()
+
+	nodes := make([]networkNodeInfo, 0)
+	for id, node := range m.cache.Nodes() {
+		nodes = append(nodes, networkNodeInfo{ID: id, Address: node.GetAddress()})
+	}
+
+	studies := make([]networkStudyInfo, 0)
+	for study, node := range m.cache.Studies() {
+		studies = append(studies, networkStudyInfo{Name: study, Node: node})
+	}
+
+	return networkInfo{
+		HTTPPort:     m.httpPortNum,
+		GRPCAddress:  m.grpcs.Addr(),
+		IfritAddress: m.ifritClient.Addr(),
+		Nodes:        nodes,
+		Studies:      studies,
+	}
+}
+
+func (m *Mux) apiNetwork(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.networkSnapshot())
+}
+
+func (m *Mux) apiListNodes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.networkSnapshot().Nodes)
+}
+
+func (m *Mux) apiStudyMetadata(w http.ResponseWriter, r *http.Request) {
+	study := mux.Vars(r)["study"]
+	if study == "" {
+		writeJSONError(w, http.StatusBadRequest, errMissingStudy)
+		return
+	}
+
+	node := r.URL.Query().Get("node")
+	if node == "" {
+		writeJSONError(w, http.StatusBadRequest, errMissingNode)
+		return
+	}
+
+	msg := message.NodeMessage{
+		MessageType: message.MSG_TYPE_GET_META_DATA,
+		Study:       study,
+		Node:        node,
+	}
+
+	statusCode, result, err := m._getMetaData(msg)
+	if err != nil {
+		writeJSONError(w, statusCode, err)
+		return
+	}
+
+	writeJSON(w, statusCode, studyMetadataResponse{
+		Study:    study,
+		Node:     node,
+		Metadata: string(result),
+	})
+}
+
+func (m *Mux) apiStudyData(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	study := mux.Vars(r)["study"]
+	if study == "" {
+		writeJSONError(w, http.StatusBadRequest, errMissingStudy)
+		return
+	}
+
+	var reqBody struct {
+		Node       string              `json:"node"`
+		Attributes map[string][]string `json:"attributes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if reqBody.Node == "" {
+		writeJSONError(w, http.StatusBadRequest, errMissingNode)
+		return
+	}
+
+	msg := message.NodeMessage{
+		MessageType: message.MSG_TYPE_GET_META_DATA,
+		Study:       study,
+		Node:        reqBody.Node,
+		Attributes:  reqBody.Attributes,
+	}
+
+	statusCode, result, err := m._getStudyData(msg)
+	if err != nil {
+		writeJSONError(w, statusCode, err)
+		return
+	}
+
+	writeJSON(w, statusCode, studyDataResponse{
+		Study: study,
+		Node:  reqBody.Node,
+		Data:  string(result),
+	})
+}
+
+// apiOpenAPISpec serves a generated OpenAPI 3 document describing the
+// /api/v1 surface, so non-Go clients (dashboards, CLIs, notebooks) can
+// generate their own typed bindings instead of reverse-engineering the API.
+func (m *Mux) apiOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec)
+}
+
+var errMissingStudy = &apiValidationError{"Missing study identifier"}
+var errMissingNode = &apiValidationError{"Missing node identifier"}
+
+type apiValidationError struct{ msg string }
+
+func (e *apiValidationError) Error() string { return e.msg }
+
+var openAPISpec = map[string]interface{}{
+	"openapi": "3.0.3",
+	"info": map[string]interface{}{
+		"title":   "Lohpi Mux API",
+		"version": "1.0.0",
+	},
+	"paths": map[string]interface{}{
+		"/api/v1/network": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Return network and node information",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{"description": "Network snapshot"},
+				},
+			},
+		},
+		"/api/v1/nodes": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "List known storage nodes",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{"description": "Node list"},
+				},
+			},
+		},
+		"/api/v1/studies/{study}/metadata": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Fetch metadata for a study",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{"description": "Study metadata"},
+					"404": map[string]interface{}{"description": "Study not found"},
+				},
+			},
+		},
+		"/api/v1/studies/{study}/data": map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary": "Fetch data for a study, subject to attribute-based access control",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{"description": "Study data"},
+					"400": map[string]interface{}{"description": "Malformed request"},
+				},
+			},
+		},
+	},
+}
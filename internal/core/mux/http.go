@@ -5,23 +5,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"context"
 	"time"
 
 	"github.com/tomcat-bit/lohpi/internal/core/message"
+	"github.com/arcsecc/lohpi/internal/logging"
 	pb "github.com/tomcat-bit/lohpi/protobuf"
 
 	"github.com/gorilla/mux"
-	logging "github.com/inconshreveable/log15"
 )
 
 func (m *Mux) HttpHandler() error {
 	r := mux.NewRouter()
-	log.Printf("MUX: Started HTTP server on port %d\n", m.httpPortNum)
+	r.Use(func(next http.Handler) http.Handler {
+		return logging.WithCorrelationID(log, next)
+	})
+	log.Info("Started HTTP server", "port", m.httpPortNum)
 
-	// Public methods exposed to data users (usually through cURL)
+	// Public methods exposed to data users (usually through cURL). Kept for
+	// backwards compatibility with existing text/plain callers; content
+	// negotiation lets Accept: application/json callers get the same data
+	// as /api/v1/network without a second round-trip.
 	r.HandleFunc("/network", m.network)
 
 	// Node API
@@ -32,6 +37,13 @@ func (m *Mux) HttpHandler() error {
 	r.HandleFunc("/study/metadata", m.GetMetaData).Methods("GET") // MORE TODO
 	r.HandleFunc("/study/data", m.GetData).Methods("POST")        // MORE TODO
 
+	// Admin API: runtime peer/node management
+	m.registerAdminRoutes(r)
+
+	// Versioned JSON REST API: the long-term replacement for the endpoints
+	// above. New integrations should target /api/v1 directly.
+	m.registerAPIv1Routes(r)
+
 	m.httpServer = &http.Server{
 		Handler: r,
 		// use timeouts?
@@ -39,13 +51,16 @@ func (m *Mux) HttpHandler() error {
 
 	err := m.httpServer.Serve(m.httpListener)
 	if err != nil {
-		logging.Error(err.Error())
+		log.Error("HTTP server exited", "error", err)
 		return err
 	}
 	return nil
 }
 
-// Returns human-readable network information and studies known to the network
+// Returns human-readable network information and studies known to the network.
+// Accept: application/json callers are transparently handed the same data
+// /api/v1/network returns, so existing scripts can opt in before the
+// text/plain format is eventually removed.
 func (m *Mux) network(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	if r.Method != http.MethodGet {
@@ -53,6 +68,11 @@ func (m *Mux) network(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wantsJSON(r) {
+		m.apiNetwork(w, r)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Mux's HTTP server running on port %d\n", m.httpPortNum)
 	fmt.Fprintf(w, "Mux's gRPC server running on address %s\n", m.grpcs.Addr())
@@ -133,7 +153,7 @@ func (m *Mux) LoadNode(w http.ResponseWriter, r *http.Request) {
 func (m *Mux) sendRecMetadata(studyName, node string, md []byte, subjects []string) error {
 	conn, err := m.recClient.Dial(m.config.RecIP)
 	if err != nil {
-		log.Println(err.Error())
+		log.Error("Failed to dial REC", "error", err)
 		return err
 	}
 	defer conn.CloseConn()
@@ -153,7 +173,7 @@ func (m *Mux) sendRecMetadata(studyName, node string, md []byte, subjects []stri
 		},
 	})
 	if err != nil {
-		log.Println(err.Error())
+		log.Error("Failed to send metadata to REC", "error", err)
 		return err
 	}
 
@@ -202,67 +222,87 @@ func (m *Mux) GetNodeInfo(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&msg)
 	if err != nil {
-		errMsg := fmt.Sprintf("Error: %s\n", err)
-		log.Printf("%s", errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		log.Error("Failed to decode node message", "error", err)
+		http.Error(w, fmt.Sprintf("Error: %s\n", err), http.StatusBadRequest)
 		return
 	}
 
 	nodeInfo, err := m.getNodeInfo(msg.Node)
 	if err != nil {
-		errMsg := fmt.Sprintf("Error: %s\n", err)
-		log.Printf("%s", errMsg)
-		http.Error(w, errMsg, http.StatusBadRequest)
+		log.Error("Failed to get node info", "node", msg.Node, "error", err)
+		http.Error(w, fmt.Sprintf("Error: %s\n", err), http.StatusBadRequest)
 		return
 	}
 	fmt.Fprintf(w, nodeInfo)
 }
 
 // Given a node identifier and a study name, return the meta-data about a particular study at that node.
-// DUMMY IMPLEMENTATION
 func (m *Mux) GetMetaData(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	/*
-		countries := []string{`["Norway"`, `"kake country]"`}
-		network := []string{`["network1"`, `"network2]"`}
-		purpose := []string{`["non-commercial"]`}
-
-		msg := &message.NodeMessage{
-			MessageType: 	message.MSG_TYPE_GET_META_DATA,
-			Study: "Sleeping and Diet patterns in Northern Norway",
-			Node: "node_0",
-		}
-
-		statusCode, result, err := m._getMetaData(*msg)
-		if err != nil {
-			http.Error(w, err.Error(), statusCode)
-			return
-		}
-
-		w.WriteHeader(statusCode)
-		fmt.Fprintf(w, "Status code: %d\tresult: %s\n", statusCode, result)*/
+	study := r.URL.Query().Get("study")
+	if study == "" {
+		http.Error(w, "Missing study identifier", http.StatusBadRequest)
+		return
+	}
+
+	node := r.URL.Query().Get("node")
+	if node == "" {
+		http.Error(w, "Missing node identifier", http.StatusBadRequest)
+		return
+	}
+
+	msg := message.NodeMessage{
+		MessageType: message.MSG_TYPE_GET_META_DATA,
+		Study:       study,
+		Node:        node,
+	}
+
+	statusCode, result, err := m._getMetaData(msg)
+	if err != nil {
+		http.Error(w, err.Error(), statusCode)
+		return
+	}
+
+	w.WriteHeader(statusCode)
+	fmt.Fprintf(w, "Status code: %d\tresult: %s\n", statusCode, result)
 }
 
-// Given a node identifier and a study name, return the data at that node
-// DUMMY IMPLEMENTATION
+// Given a node identifier, a study name and a set of attributes, return the
+// data at that node. The attributes are what policy evaluation is run
+// against, which is why (unlike GetMetaData) this is a POST with a JSON body
+// rather than query parameters.
 func (m *Mux) GetData(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	countries := []string{`["Norway"`, `"kake country]"`}
-	network := []string{`["network1"`, `"network2]"`}
-	purpose := []string{`["non-commercial"]`}
+	var reqBody struct {
+		Study      string              `json:"study"`
+		Node       string              `json:"node"`
+		Attributes map[string][]string `json:"attributes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if reqBody.Study == "" {
+		http.Error(w, "Missing study identifier", http.StatusBadRequest)
+		return
+	}
+
+	if reqBody.Node == "" {
+		http.Error(w, "Missing node identifier", http.StatusBadRequest)
+		return
+	}
 
-	msg := &message.NodeMessage{
+	msg := message.NodeMessage{
 		MessageType: message.MSG_TYPE_GET_META_DATA,
-		Study:       "Sleeping and Diet patterns in Northern Norway",
-		Node:        "node_0",
-		Attributes: map[string][]string{"country": countries,
-			"research_network": network,
-			"purpose":          purpose},
+		Study:       reqBody.Study,
+		Node:        reqBody.Node,
+		Attributes:  reqBody.Attributes,
 	}
 
-	statusCode, result, err := m._getStudyData(*msg)
+	statusCode, result, err := m._getStudyData(msg)
 	if err != nil {
 		http.Error(w, err.Error(), statusCode)
 		return
@@ -0,0 +1,11 @@
+package mux
+
+import (
+	"github.com/hashicorp/go-hclog"
+	"github.com/arcsecc/lohpi/internal/logging"
+)
+
+// log is the root logger for this package. It replaces the previous mix of
+// log15 and the stdlib log package with a single hclog sink so every line
+// this package emits shares one level, format and correlation ID scheme.
+var log hclog.Logger = logging.New("mux", logging.ConfigFromEnv())
@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// correlationIDKey is the context key the correlation ID is stored under.
+type correlationIDKey struct{}
+
+// CorrelationIDHeader is the response header carrying the per-request
+// correlation ID, so a caller can correlate its own logs with the server's.
+const CorrelationIDHeader = "X-Request-ID"
+
+// WithCorrelationID wraps next so every request gets a correlation ID: one
+// is read from the incoming X-Request-ID header if present, otherwise a new
+// one is minted. The ID is attached to the request context and echoed back
+// on the response. The one "handling request" debug line logged here carries
+// it; other log lines carry it only if their code reads it back with
+// CorrelationIDFromContext.
+func WithCorrelationID(logger hclog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CorrelationIDHeader)
+		if id == "" {
+			id = NewCorrelationID()
+		}
+
+		w.Header().Set(CorrelationIDHeader, id)
+		ctx := context.WithValue(r.Context(), correlationIDKey{}, id)
+
+		reqLogger := logger.With("correlation_id", id, "method", r.Method, "path", r.URL.Path)
+		reqLogger.Debug("handling request")
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CorrelationIDFromContext returns the correlation ID attached by
+// WithCorrelationID, or "" if none is present.
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
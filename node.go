@@ -3,6 +3,7 @@ package lohpi
 import (
 	"github.com/pkg/errors"
 	"github.com/arcsecc/lohpi/core/node"
+	"net/url"
 	"time"
 )
 
@@ -105,9 +106,99 @@ func NodeWithHostName(hostName string) NodeOption {
 	}
 }
 
+// Sets the TTL of a dataset checkout lease. A client must call
+// POST /dataset/checkout/{id}/refresh before the lease expires to keep its
+// checkout alive; otherwise the background sweeper frees it. Default is 15
+// minutes.
+func NodeWithCheckoutLeaseTTL(ttl time.Duration) NodeOption {
+	return func(n *Node) {
+		n.conf.CheckoutLeaseTTL = ttl
+	}
+}
+
+// Trusts issuerURL as an OIDC identity provider for bearer tokens presented
+// to the dataset endpoints. audience is the expected "aud" claim and clientID
+// the expected authorized party. Calling this more than once (or passing
+// several NodeOption values, one per provider) trusts each provider in turn;
+// a token is accepted if it verifies against any of them, which is useful
+// when migrating from one IdP to another without a flag day. Default is no
+// provider configured, in which case token signature/claim validation is
+// skipped entirely.
+func NodeWithOIDCProvider(issuerURL, audience, clientID string) NodeOption {
+	return func(n *Node) {
+		n.conf.OIDCProviders = append(n.conf.OIDCProviders, node.OIDCProviderConfig{
+			IssuerURL: issuerURL,
+			Audience:  audience,
+			ClientID:  clientID,
+		})
+	}
+}
+
+// Sets the buffer size used by io.CopyBuffer when streaming dataset/metadata
+// responses to clients. Default is 32 KiB.
+func NodeWithStreamBufferSize(size int) NodeOption {
+	return func(n *Node) {
+		n.conf.StreamBufferSize = size
+	}
+}
+
+// WebhookOption configures a single sink passed to NodeWithWebhook.
+type WebhookOption func(*node.WebhookSinkConfig)
+
+// WebhookWithAuthToken sends "Authorization: Splunk <token>" with every
+// delivery to this sink, matching what Splunk's HTTP Event Collector expects.
+func WebhookWithAuthToken(token string) WebhookOption {
+	return func(c *node.WebhookSinkConfig) {
+		c.AuthToken = token
+	}
+}
+
+// WebhookWithHMACSecret signs every delivered event body with HMAC-SHA256
+// using secret, sent as the hex-encoded X-Lohpi-Signature header, so the
+// receiving end can verify the event actually came from this node.
+func WebhookWithHMACSecret(secret []byte) WebhookOption {
+	return func(c *node.WebhookSinkConfig) {
+		c.HMACSecret = secret
+	}
+}
+
+// WebhookWithQueueSize bounds how many undelivered events this sink buffers
+// before it starts dropping the oldest one. Default is 256.
+func WebhookWithQueueSize(size int) WebhookOption {
+	return func(c *node.WebhookSinkConfig) {
+		c.QueueSize = size
+	}
+}
+
+// WebhookWithMaxRetries bounds the exponential backoff retry loop this sink
+// runs per event before giving up on it. Default is 5.
+func WebhookWithMaxRetries(retries int) WebhookOption {
+	return func(c *node.WebhookSinkConfig) {
+		c.MaxRetries = retries
+	}
+}
+
+// NodeWithWebhook registers target as a sink for dataset lifecycle events
+// (see the node package's EventDataset* constants). Calling this more than
+// once registers multiple sinks; more sinks can be added at runtime via
+// POST /admin/webhooks without restarting the node.
+func NodeWithWebhook(target *url.URL, opts ...WebhookOption) NodeOption {
+	return func(n *Node) {
+		cfg := node.WebhookSinkConfig{Target: target}
+		for _, opt := range opts {
+			opt(&cfg)
+		}
+		n.conf.WebhookSinks = append(n.conf.WebhookSinks, cfg)
+	}
+}
+
 // Applies the options to the node.
 // NOTE: no locking is performed. Beware of undefined behaviour. Check that previous connections are still valid.
-// SHOULD NOT be called.
+// SHOULD NOT be called. To rotate the policy store, directory server or mux
+// address of a running node, use POST/DELETE /admin/peers instead: it goes
+// through the node's peerRegistry, re-handshaking with the new peer
+// immediately and updating the address JoinNetwork reconnects with, without
+// restarting the node.
 func (n *Node) ApplyConfigurations(opts ...NodeOption) {
 	for _, opt := range opts {
 		opt(n)